@@ -1,7 +1,6 @@
 package cuei
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -75,7 +74,8 @@ func mkJson(i interface{}) string {
 func Json2Cue(s string) *Cue {
 	b := []byte(s)
 	cue := NewCue()
-	json.Unmarshal(b, cue)
+	err := json.Unmarshal(b, cue)
+	chk(err)
 	cue.Encode()
 	return cue
 }
@@ -91,11 +91,3 @@ func parsePid(byte1, byte2 byte) uint16 {
 func parsePrgm(byte1, byte2 byte) uint16 {
 	return uint16(byte1)<<8 | uint16(byte2)
 }
-
-func splitByIdx(payload, sep []byte) []byte {
-	idx := bytes.Index(payload, sep)
-	if idx == -1 {
-		return []byte("")
-	}
-	return payload[idx:]
-}
@@ -130,8 +130,13 @@ Add left shifts Encoder.Bites by nbits and add val interface{} as bits.
 Supports val as bool, float64, int, uint8, uint16, uint32,or  uint64.
 */
 func (be *bitEncoder) Add(val interface{}, nbits uint) {
+	u := u64(val)
+	if nbits < 64 {
+		// keep values like a 33 bit PTS from spilling into the next field
+		u &= 1<<nbits - 1
+	}
 	t := new(big.Int)
-	t.SetUint64(u64(val))
+	t.SetUint64(u)
 	o := be.Bites.Lsh(&be.Bites, nbits)
 	be.Bites = *be.Bites.Add(o, t)
 }
@@ -147,6 +152,17 @@ func (be *bitEncoder) AddHex64(val string, nbits uint) {
 	}
 }
 
+// AddHex32 append a hex string as uint32 in bits
+func (be *bitEncoder) AddHex32(val string, nbits uint) {
+	u := new(big.Int)
+	_, err := fmt.Sscan(val, u)
+	if err != nil {
+		fmt.Println("error scanning value:", err)
+	} else {
+		be.Add(uint32(u.Uint64()), nbits)
+	}
+}
+
 // Reserve left shifts Encoder.Bites by num and adds num bits  set to 1
 func (be *bitEncoder) Reserve(num int) {
 	for i := 0; i < num; i++ {
@@ -178,6 +178,9 @@ func (cmd *Command) decodeSpliceInsert(bd *bitDecoder) {
 	cmd.SpliceEventID = bd.uInt32(32)
 	cmd.SpliceEventCancelIndicator = bd.asFlag()
 	bd.goForward(7)
+	if cmd.SpliceEventCancelIndicator {
+		return
+	}
 	cmd.OutOfNetworkIndicator = bd.asFlag()
 	cmd.ProgramSpliceFlag = bd.asFlag()
 	cmd.DurationFlag = bd.asFlag()
@@ -205,6 +208,9 @@ func (cmd *Command) encodeSpliceInsert() []byte {
 	be.Add(cmd.SpliceEventID, 32)
 	be.Add(cmd.SpliceEventCancelIndicator, 1)
 	be.Reserve(7)
+	if cmd.SpliceEventCancelIndicator {
+		return be.Bites.Bytes()[1:]
+	}
 	be.Add(cmd.OutOfNetworkIndicator, 1)
 	be.Add(cmd.ProgramSpliceFlag, 1)
 	be.Add(cmd.DurationFlag, 1)
@@ -0,0 +1,126 @@
+package cuei
+
+import (
+	"fmt"
+)
+
+// nullPid is stuffing, it has no continuity counter.
+const nullPid = 0x1fff
+
+// TsEvent types
+const (
+	CcError       = "CC Error"
+	TeiError      = "Transport Error"
+	Discontinuity = "Discontinuity"
+)
+
+/*
+PidStats counts packets and transport errors for a PID,
+roughly TR 101 290 priority 1 checks.
+
+	CcErrors are missing, out of order or repeated packets
+	TeiErrors are packets with the transport_error_indicator set
+	Discontinuities are packets with the discontinuity_indicator set
+	Duplicates are packets sent twice, allowed once in a row
+*/
+type PidStats struct {
+	Packets         uint64
+	CcErrors        uint64
+	TeiErrors       uint64
+	Discontinuities uint64
+	Duplicates      uint64
+	cc              uint8
+	seen            bool
+	dupe            bool
+}
+
+// TsEvent is passed to the Stream.HandleTsEvents func on a transport error.
+type TsEvent struct {
+	Type     string
+	Pid      uint16
+	Expected uint8 `json:",omitempty"` // expected continuity counter for CC Errors
+	Cc       uint8
+}
+
+// Json returns the TsEvent as JSON
+func (tev *TsEvent) Json() string {
+	return mkJson(tev)
+}
+
+// Show prints the TsEvent as JSON
+func (tev *TsEvent) Show() {
+	fmt.Println(tev.Json())
+}
+
+/*
+HandleTsEvents registers fn to be called with each
+CC Error, Transport Error and Discontinuity as it is found.
+Counts by PID are in Stream.Pid2Stats.
+*/
+func (stream *Stream) HandleTsEvents(fn func(*TsEvent)) {
+	stream.tsHandler = fn
+}
+
+// tsEvent passes an event to the func set by Stream.HandleTsEvents.
+func (stream *Stream) tsEvent(typ string, pid uint16, expected uint8, cc uint8) {
+	if stream.tsHandler != nil {
+		stream.tsHandler(&TsEvent{Type: typ, Pid: pid, Expected: expected, Cc: cc})
+	}
+}
+
+/*
+checkCc checks the transport error indicator and continuity counter of pkt.
+A partial section for the PID is dropped when packets are lost.
+It returns false if pkt should not be parsed, errored or duplicate packets.
+*/
+func (stream *Stream) checkCc(pkt []byte, pid uint16) bool {
+	stats, ok := stream.Pid2Stats[pid]
+	if !ok {
+		stats = &PidStats{}
+		stream.Pid2Stats[pid] = stats
+	}
+	stats.Packets++
+	cc := pkt[3] & 0x0f
+	if pkt[1]&0x80 == 0x80 {
+		stats.TeiErrors++
+		stats.seen = false
+		delete(stream.partial, pid)
+		stream.tsEvent(TeiError, pid, 0, cc)
+		return false
+	}
+	if pid == nullPid {
+		return true
+	}
+	if stream.afcFlag(pkt) && pkt[4] > 0 && pkt[5]&0x80 == 0x80 {
+		stats.Discontinuities++
+		stats.seen = false
+		delete(stream.partial, pid)
+		stream.tsEvent(Discontinuity, pid, 0, cc)
+	}
+	hasPay := pkt[3]&0x10 == 0x10
+	if !stats.seen {
+		stats.seen = true
+		stats.cc = cc
+		stats.dupe = false
+		return true
+	}
+	expected := stats.cc
+	if hasPay {
+		expected = (stats.cc + 1) & 0x0f
+	}
+	switch {
+	case cc == expected:
+		stats.dupe = false
+	case hasPay && cc == stats.cc && !stats.dupe:
+		stats.Duplicates++
+		stats.dupe = true
+		return false
+	default:
+		stats.CcErrors++
+		stats.dupe = false
+		delete(stream.partial, pid)
+		stream.tsEvent(CcError, pid, expected, cc)
+	}
+	stats.cc = cc
+	return true
+}
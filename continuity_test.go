@@ -0,0 +1,131 @@
+package cuei
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// ccPkt returns a packet on pid with cc, flags are "p" payload, "t" TEI, "d" discontinuity.
+func ccPkt(pid uint16, cc uint8, flags string) []byte {
+	pkt := make([]byte, pktSz)
+	pkt[0] = syncByte
+	pkt[1] = byte(pid >> 8 & 0x1f)
+	pkt[2] = byte(pid)
+	pkt[3] = cc & 0x0f
+	if strings.Contains(flags, "t") {
+		pkt[1] |= 0x80
+	}
+	if strings.Contains(flags, "p") {
+		pkt[3] |= 0x10
+	}
+	if strings.Contains(flags, "d") {
+		pkt[3] |= 0x20
+		pkt[4] = 1
+		pkt[5] = 0x80
+	}
+	return pkt
+}
+
+func TestCheckCc(t *testing.T) {
+	type step struct {
+		cc    uint8
+		flags string
+		ok    bool
+	}
+	tests := []struct {
+		name   string
+		steps  []step
+		stats  PidStats
+		events []string
+	}{
+		{
+			"in order, wrapping",
+			[]step{{14, "p", true}, {15, "p", true}, {0, "p", true}, {1, "p", true}},
+			PidStats{Packets: 4},
+			nil,
+		},
+		{
+			"gap",
+			[]step{{0, "p", true}, {1, "p", true}, {3, "p", true}, {4, "p", true}},
+			PidStats{Packets: 4, CcErrors: 1},
+			[]string{CcError},
+		},
+		{
+			"out of order",
+			[]step{{0, "p", true}, {2, "p", true}, {1, "p", true}},
+			PidStats{Packets: 3, CcErrors: 2},
+			[]string{CcError, CcError},
+		},
+		{
+			"duplicate",
+			[]step{{0, "p", true}, {1, "p", true}, {1, "p", false}, {2, "p", true}},
+			PidStats{Packets: 4, Duplicates: 1},
+			nil,
+		},
+		{
+			"duplicate twice",
+			[]step{{0, "p", true}, {1, "p", true}, {1, "p", false}, {1, "p", true}},
+			PidStats{Packets: 4, Duplicates: 1, CcErrors: 1},
+			[]string{CcError},
+		},
+		{
+			"no payload keeps cc",
+			[]step{{0, "p", true}, {0, "", true}, {1, "p", true}},
+			PidStats{Packets: 3},
+			nil,
+		},
+		{
+			"discontinuity",
+			[]step{{0, "p", true}, {1, "p", true}, {9, "pd", true}, {10, "p", true}},
+			PidStats{Packets: 4, Discontinuities: 1},
+			[]string{Discontinuity},
+		},
+		{
+			"transport error",
+			[]step{{0, "p", true}, {1, "pt", false}, {5, "p", true}, {6, "p", true}},
+			PidStats{Packets: 4, TeiErrors: 1},
+			[]string{TeiError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewStream()
+			var events []string
+			stream.HandleTsEvents(func(tev *TsEvent) { events = append(events, tev.Type) })
+			for i, st := range tt.steps {
+				if ok := stream.checkCc(ccPkt(0x101, st.cc, st.flags), 0x101); ok != st.ok {
+					t.Fatalf("packet %d, cc %d: got %v, want %v", i, st.cc, ok, st.ok)
+				}
+			}
+			stats := *stream.Pid2Stats[0x101]
+			stats.cc, stats.seen, stats.dupe = 0, false, false
+			if stats != tt.stats {
+				t.Fatalf("stats are %+v, want %+v", stats, tt.stats)
+			}
+			if strings.Join(events, ",") != strings.Join(tt.events, ",") {
+				t.Fatalf("events are %v, want %v", events, tt.events)
+			}
+		})
+	}
+}
+
+func TestCcErrorDropsPartial(t *testing.T) {
+	// a section too big for one packet
+	big := segCue(0x09, bytes.Repeat([]byte{'A'}, 200))
+	ts := testTs(big, big)
+	// drop the second packet of the first cue
+	ts = append(ts[:3*pktSz], ts[4*pktSz:]...)
+	stream := NewStream()
+	stream.Quiet = true
+	cues := stream.DecodeBytes(ts)
+	if len(cues) != 1 {
+		t.Fatalf("got %d Cues, want 1", len(cues))
+	}
+	if cues[0].Descriptors[0].SegmentationUpid.Value != strings.Repeat("A", 200) {
+		t.Fatalf("UPID is %q", cues[0].Descriptors[0].SegmentationUpid.Value)
+	}
+	if stats := stream.Pid2Stats[testScte35Pid]; stats.CcErrors != 1 {
+		t.Fatalf("stats are %+v", stats)
+	}
+}
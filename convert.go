@@ -0,0 +1,206 @@
+package cuei
+
+import (
+	"fmt"
+)
+
+/*
+Six2FiveRules maps SegmentationTypeIDs to Splice Inserts.
+
+	Outs trigger CUE-OUTs
+	Ins trigger CUE-INs
+*/
+type Six2FiveRules struct {
+	Outs []uint16
+	Ins  []uint16
+}
+
+/*
+NewSix2FiveRules returns the rules used by Cue.Six2Five.
+
+	Outs: 0x22, 0x30, 0x32, 0x34, 0x36, 0x44, 0x46
+	Ins:  0x23, 0x31, 0x33, 0x35, 0x37, 0x45, 0x47
+*/
+func NewSix2FiveRules() *Six2FiveRules {
+	return &Six2FiveRules{
+		Outs: []uint16{0x22, 0x30, 0x32, 0x34, 0x36, 0x44, 0x46},
+		Ins:  []uint16{0x23, 0x31, 0x33, 0x35, 0x37, 0x45, 0x47},
+	}
+}
+
+/*
+ToSpliceInserts converts a Time Signal into new Splice Insert Cues,
+one for each Segmentation Descriptor matched by rules.
+The Cue is not changed. A nil rules uses NewSix2FiveRules.
+
+Each Splice Insert carries the matched Segmentation Descriptor,
+so the Upid is kept, and any Avail Descriptors.
+Segmentation Descriptors that cancel an event become Splice Insert cancels.
+
+Descriptors that could not be mapped are returned as unmapped.
+*/
+func (cue *Cue) ToSpliceInserts(rules *Six2FiveRules) (cues []*Cue, unmapped []Descriptor) {
+	if cue.Command == nil || cue.Command.CommandType != 6 {
+		return nil, nil
+	}
+	if rules == nil {
+		rules = NewSix2FiveRules()
+	}
+	var avails []Descriptor
+	for _, dscptr := range cue.Descriptors {
+		if dscptr.Tag == 0 {
+			avails = append(avails, dscptr.clone())
+		}
+	}
+	for _, dscptr := range cue.Descriptors {
+		switch dscptr.Tag {
+		case 0:
+			continue
+		case 2:
+			segType := uint16(dscptr.SegmentationTypeID)
+			out := IsIn(rules.Outs, segType)
+			if !dscptr.SegmentationEventCancelIndicator && !out && !IsIn(rules.Ins, segType) {
+				unmapped = append(unmapped, dscptr)
+				continue
+			}
+			five := cue.newSpliceInsert(dscptr)
+			five.Command.OutOfNetworkIndicator = out
+			if out && dscptr.SegmentationDurationFlag {
+				five.Command.DurationFlag = true
+				five.Command.BreakAutoReturn = true
+				five.Command.BreakDuration = dscptr.SegmentationDuration
+			}
+			five.Descriptors = append(five.Descriptors, avails...)
+			five.Descriptors = append(five.Descriptors, dscptr.clone())
+			five.Encode()
+			cues = append(cues, five)
+		default:
+			unmapped = append(unmapped, dscptr)
+		}
+	}
+	return cues, unmapped
+}
+
+// newSpliceInsert returns a new Splice Insert Cue for a Segmentation Descriptor.
+func (cue *Cue) newSpliceInsert(dscptr Descriptor) *Cue {
+	five := NewCue()
+	infosec := *cue.InfoSection
+	five.InfoSection = &infosec
+	five.Command = &Command{}
+	five.Command.CommandType = 5
+	five.Command.Name = "Splice Insert"
+	five.Command.SpliceEventID = uint32(hex2Int(dscptr.SegmentationEventID))
+	five.Command.SpliceEventCancelIndicator = dscptr.SegmentationEventCancelIndicator
+	five.Command.ProgramSpliceFlag = true
+	five.Command.EventIDComplianceFlag = true
+	five.Command.AvailNum = dscptr.SegmentNum
+	five.Command.AvailExpected = dscptr.SegmentsExpected
+	if cue.Command.TimeSpecifiedFlag {
+		five.Command.TimeSpecifiedFlag = true
+		five.Command.PTS = cue.Command.PTS
+	} else {
+		five.Command.SpliceImmediateFlag = true
+	}
+	return five
+}
+
+// clone returns a copy of the Descriptor that shares no memory with it.
+func (dscptr *Descriptor) clone() Descriptor {
+	dupe := *dscptr
+	if dscptr.SegmentationUpid != nil {
+		upid := dscptr.SegmentationUpid.clone()
+		dupe.SegmentationUpid = &upid
+	}
+	return dupe
+}
+
+/*
+Five2SixRules sets the SegmentationTypeIDs used by Cue.ToTimeSignal.
+
+	Out is used for CUE-OUTs
+	In is used for CUE-INs
+*/
+type Five2SixRules struct {
+	Out uint8
+	In  uint8
+}
+
+/*
+NewFive2SixRules returns rules for
+Provider Placement Opportunity Start (0x34) and End (0x35).
+Use 0x36 and 0x37 for Distributor Placement Opportunities.
+*/
+func NewFive2SixRules() *Five2SixRules {
+	return &Five2SixRules{
+		Out: 0x34,
+		In:  0x35,
+	}
+}
+
+/*
+ToTimeSignal converts a Splice Insert into a new Time Signal Cue
+with a Segmentation Descriptor. The Cue is not changed.
+A nil rules uses NewFive2SixRules.
+
+	SpliceEventID becomes SegmentationEventID
+	BreakDuration becomes SegmentationDuration
+	AvailNum and AvailExpected become SegmentNum and SegmentsExpected
+	SpliceEventCancelIndicator becomes SegmentationEventCancelIndicator
+
+Avail Descriptors are carried over.
+*/
+func (cue *Cue) ToTimeSignal(rules *Five2SixRules) *Cue {
+	if cue.Command == nil || cue.Command.CommandType != 5 {
+		return nil
+	}
+	if rules == nil {
+		rules = NewFive2SixRules()
+	}
+	six := NewCue()
+	infosec := *cue.InfoSection
+	six.InfoSection = &infosec
+	six.Command = &Command{}
+	six.Command.CommandType = 6
+	six.Command.Name = "Time Signal"
+	if !cue.Command.SpliceImmediateFlag && cue.Command.TimeSpecifiedFlag {
+		six.Command.TimeSpecifiedFlag = true
+		six.Command.PTS = cue.Command.PTS
+	}
+	for _, dscptr := range cue.Descriptors {
+		if dscptr.Tag == 0 {
+			six.Descriptors = append(six.Descriptors, dscptr.clone())
+		}
+	}
+	segType := rules.In
+	if cue.Command.OutOfNetworkIndicator {
+		segType = rules.Out
+	}
+	six.Descriptors = append(six.Descriptors, cue.mkSegmentationDescriptor(segType))
+	six.Encode()
+	return six
+}
+
+// mkSegmentationDescriptor makes a Segmentation Descriptor from a Splice Insert.
+func (cue *Cue) mkSegmentationDescriptor(segType uint8) Descriptor {
+	var dscptr Descriptor
+	dscptr.Tag = 2
+	dscptr.Name = "Segmentation Descriptor"
+	dscptr.Identifier = "CUEI"
+	dscptr.SegmentationEventID = fmt.Sprintf("%#x", cue.Command.SpliceEventID)
+	dscptr.SegmentationEventIDComplianceIndicator = true
+	dscptr.SegmentationEventCancelIndicator = cue.Command.SpliceEventCancelIndicator
+	if !dscptr.SegmentationEventCancelIndicator {
+		dscptr.ProgramSegmentationFlag = true
+		dscptr.DeliveryNotRestrictedFlag = true
+		if cue.Command.DurationFlag {
+			dscptr.SegmentationDurationFlag = true
+			dscptr.SegmentationDuration = cue.Command.BreakDuration
+		}
+		dscptr.SegmentationTypeID = segType
+		dscptr.SegmentationMessage = table22[segType]
+		dscptr.SegmentNum = cue.Command.AvailNum
+		dscptr.SegmentsExpected = cue.Command.AvailExpected
+	}
+	dscptr.SegmentationDescriptor.TagLenNameId = dscptr.TagLenNameId
+	return dscptr
+}
@@ -0,0 +1,232 @@
+package cuei
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// availDscptr returns an Avail Descriptor for provider avail id.
+func availDscptr(id uint32) []byte {
+	dscptr := []byte{0x00, 8, 'C', 'U', 'E', 'I', 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(dscptr[6:10], id)
+	return dscptr
+}
+
+// segDscptr returns a Segmentation Descriptor with an MPU Upid, dur is in 90k ticks, zero for none.
+func segDscptr(id uint32, segType uint8, dur uint64, private []byte) []byte {
+	dscptr := []byte{0x02, 0, 'C', 'U', 'E', 'I', 0, 0, 0, 0, 0x7f, 0xbf}
+	binary.BigEndian.PutUint32(dscptr[6:10], id)
+	if dur > 0 {
+		dscptr[11] = 0xff
+		dscptr = append(dscptr, byte(dur>>32), byte(dur>>24), byte(dur>>16), byte(dur>>8), byte(dur))
+	}
+	dscptr = append(dscptr, 0x0c, byte(4+len(private)), 'A', 'B', 'C', 'D')
+	dscptr = append(dscptr, private...)
+	dscptr = append(dscptr, segType, 1, 2)
+	if IsIn([]uint16{0x30, 0x32, 0x34, 0x36, 0x38, 0x3a, 0x44, 0x46}, uint16(segType)) {
+		dscptr = append(dscptr, 0, 0)
+	}
+	dscptr[1] = byte(len(dscptr) - 2)
+	return dscptr
+}
+
+// cancelDscptr returns a Segmentation Descriptor cancelling event id.
+func cancelDscptr(id uint32) []byte {
+	dscptr := []byte{0x02, 9, 'C', 'U', 'E', 'I', 0, 0, 0, 0, 0xff}
+	binary.BigEndian.PutUint32(dscptr[6:10], id)
+	return dscptr
+}
+
+// timeSignal returns a Time Signal section at pts 0x0abcde with dscptrs.
+func timeSignal(dscptrs ...[]byte) []byte {
+	sec := []byte{0xfc, 0x30, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xf0, 5, 6, 0xfe, 0, 0x0a, 0xbc, 0xde}
+	loop := cat(dscptrs...)
+	sec = append(sec, byte(len(loop)>>8), byte(len(loop)))
+	return finishSection(append(sec, loop...))
+}
+
+func TestToSpliceInserts(t *testing.T) {
+	src := timeSignal(
+		availDscptr(77),
+		segDscptr(1, 0x34, 30*90000, []byte{1, 2, 3}),
+		cancelDscptr(2),
+		segDscptr(3, 0x35, 0, []byte{4, 5}),
+		segDscptr(4, 0x10, 0, []byte{6}),
+	)
+	cue := NewCue()
+	cue.Decode(src)
+	before := mkJson(cue)
+	fives, unmapped := cue.ToSpliceInserts(nil)
+	if len(fives) != 3 || len(unmapped) != 1 || unmapped[0].SegmentationTypeID != 0x10 {
+		t.Fatalf("got %d Splice Inserts and %d unmapped", len(fives), len(unmapped))
+	}
+	tests := []struct {
+		id       uint32
+		out      bool
+		cancel   bool
+		duration float64
+	}{
+		{1, true, false, 30},
+		{2, false, true, 0},
+		{3, false, false, 0},
+	}
+	for i, tt := range tests {
+		cmd := fives[i].Command
+		if cmd.CommandType != 5 || cmd.SpliceEventID != tt.id {
+			t.Fatalf("Splice Insert %d is command %d, event %d", i, cmd.CommandType, cmd.SpliceEventID)
+		}
+		if cmd.OutOfNetworkIndicator != tt.out || cmd.SpliceEventCancelIndicator != tt.cancel {
+			t.Fatalf("event %d: out %v, cancel %v", tt.id, cmd.OutOfNetworkIndicator, cmd.SpliceEventCancelIndicator)
+		}
+		if cmd.DurationFlag != (tt.duration > 0) || cmd.BreakDuration != tt.duration {
+			t.Fatalf("event %d: BreakDuration is %v", tt.id, cmd.BreakDuration)
+		}
+		if !tt.cancel && (!cmd.TimeSpecifiedFlag || cmd.PTS != cue.Command.PTS) {
+			t.Fatalf("event %d: PTS is %v, want %v", tt.id, cmd.PTS, cue.Command.PTS)
+		}
+		dscptrs := fives[i].Descriptors
+		if len(dscptrs) != 2 || dscptrs[0].Tag != 0 || dscptrs[0].ProviderAvailID != 77 {
+			t.Fatalf("event %d: avail descriptor not copied: %v", tt.id, mkJson(dscptrs))
+		}
+		if dscptrs[1].Tag != 2 || dscptrs[1].SegmentationEventID != cue.Descriptors[i+1].SegmentationEventID {
+			t.Fatalf("event %d: segmentation descriptor not copied: %v", tt.id, mkJson(dscptrs))
+		}
+	}
+	// change everything the Splice Inserts share with the Cue
+	for _, five := range fives {
+		five.InfoSection.PtsAdjustment = 1
+		five.Command.PTS = 1
+		for i := range five.Descriptors {
+			dscptr := &five.Descriptors[i]
+			dscptr.ProviderAvailID = 1
+			if dscptr.SegmentationUpid != nil {
+				dscptr.SegmentationUpid.FormatIdentifier = "0x0"
+				for j := range dscptr.SegmentationUpid.PrivateData {
+					dscptr.SegmentationUpid.PrivateData[j] = 0
+				}
+			}
+		}
+	}
+	if after := mkJson(cue); after != before {
+		t.Fatalf("Cue changed:\n%v\n%v", before, after)
+	}
+}
+
+func TestDescriptorClone(t *testing.T) {
+	var dscptr Descriptor
+	dscptr.Tag = 2
+	dscptr.SegmentationUpid = &Upid{
+		UpidType: 0x0d,
+		Upids: []Upid{
+			{UpidType: 0x0b, ContentID: []byte{1, 2}},
+			{UpidType: 0x0c, PrivateData: []byte{3, 4}},
+		},
+	}
+	dupe := dscptr.clone()
+	dupe.SegmentationUpid.Upids[0].ContentID[0] = 9
+	dupe.SegmentationUpid.Upids[1].PrivateData[0] = 9
+	dupe.SegmentationUpid.Upids[1].UpidType = 9
+	upids := dscptr.SegmentationUpid.Upids
+	if upids[0].ContentID[0] != 1 || upids[1].PrivateData[0] != 3 || upids[1].UpidType != 0x0c {
+		t.Fatalf("clone shares memory: %v", mkJson(upids))
+	}
+}
+
+// spliceInsert returns a Splice Insert section with an Avail Descriptor,
+// flags are "o" out of network, "c" cancel, "i" splice immediate, "d" a 30 second break.
+func spliceInsert(id uint32, flags string) []byte {
+	cmd := []byte{5, 0, 0, 0, 0, 0x7f}
+	binary.BigEndian.PutUint32(cmd[1:5], id)
+	if strings.Contains(flags, "c") {
+		cmd[5] = 0xff
+	} else {
+		opts := byte(0x4f)
+		if strings.Contains(flags, "o") {
+			opts |= 0x80
+		}
+		if strings.Contains(flags, "d") {
+			opts |= 0x20
+		}
+		if strings.Contains(flags, "i") {
+			opts |= 0x10
+		}
+		cmd = append(cmd, opts)
+		if !strings.Contains(flags, "i") {
+			cmd = append(cmd, 0xfe, 0, 0x0a, 0xbc, 0xde)
+		}
+		if strings.Contains(flags, "d") {
+			cmd = append(cmd, 0xfe, 0, 0x29, 0x32, 0xe0)
+		}
+		cmd = append(cmd, 0, 0, 1, 2)
+	}
+	sec := []byte{0xfc, 0x30, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xf0, byte(len(cmd) - 1)}
+	sec = append(sec, cmd...)
+	loop := availDscptr(5)
+	sec = append(sec, 0, byte(len(loop)))
+	return finishSection(append(sec, loop...))
+}
+
+func TestToTimeSignal(t *testing.T) {
+	pts := float64(0x0abcde) / 90000.0
+	tests := []struct {
+		name     string
+		flags    string
+		rules    *Five2SixRules
+		segType  uint8
+		cancel   bool
+		pts      float64
+		duration float64
+	}{
+		{"out", "o", nil, 0x34, false, pts, 0},
+		{"out with a break", "od", nil, 0x34, false, pts, 30},
+		{"in", "", nil, 0x35, false, pts, 0},
+		{"distributor out", "od", &Five2SixRules{Out: 0x36, In: 0x37}, 0x36, false, pts, 30},
+		{"distributor in", "", &Five2SixRules{Out: 0x36, In: 0x37}, 0x37, false, pts, 0},
+		{"splice immediate", "oi", nil, 0x34, false, 0, 0},
+		{"cancel", "c", nil, 0, true, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := spliceInsert(0x1234, tt.flags)
+			cue := NewCue()
+			cue.Decode(src)
+			before := mkJson(cue)
+			six := cue.ToTimeSignal(tt.rules)
+			if mkJson(cue) != before {
+				t.Fatal("Splice Insert changed")
+			}
+			// decode the encoded Time Signal
+			again := NewCue()
+			if !again.Decode(six.Encode()) {
+				t.Fatal("Time Signal didn't decode")
+			}
+			cmd := again.Command
+			if cmd.CommandType != 6 || cmd.TimeSpecifiedFlag != (tt.pts > 0) || cmd.PTS != tt.pts {
+				t.Fatalf("command %d, time specified %v, PTS %v", cmd.CommandType, cmd.TimeSpecifiedFlag, cmd.PTS)
+			}
+			if len(again.Descriptors) != 2 || again.Descriptors[0].Tag != 0 || again.Descriptors[0].ProviderAvailID != 5 {
+				t.Fatalf("avail descriptor not copied: %v", mkJson(again.Descriptors))
+			}
+			dscptr := again.Descriptors[1]
+			if dscptr.Tag != 2 || dscptr.SegmentationEventID != "0x1234" || dscptr.SegmentationEventCancelIndicator != tt.cancel {
+				t.Fatalf("segmentation descriptor is %v", mkJson(dscptr))
+			}
+			if dscptr.SegmentationTypeID != tt.segType {
+				t.Fatalf("SegmentationTypeID is %#x, want %#x", dscptr.SegmentationTypeID, tt.segType)
+			}
+			if dscptr.SegmentationDurationFlag != (tt.duration > 0) || dscptr.SegmentationDuration != tt.duration {
+				t.Fatalf("SegmentationDuration is %v, want %v", dscptr.SegmentationDuration, tt.duration)
+			}
+			if !tt.cancel && (dscptr.SegmentNum != 1 || dscptr.SegmentsExpected != 2) {
+				t.Fatalf("SegmentNum %d, SegmentsExpected %d", dscptr.SegmentNum, dscptr.SegmentsExpected)
+			}
+		})
+	}
+	// only Splice Inserts convert
+	cue := NewCue()
+	cue.Decode(timeSignal())
+	if six := cue.ToTimeSignal(nil); six != nil {
+		t.Fatalf("Time Signal converted to %v", mkJson(six))
+	}
+}
@@ -38,10 +38,21 @@ func mkTable() [twoFiftySix]int {
 
 // MkCrc32 generate a 32 bit Crc as hex
 func MkCrc32(data []byte) string {
+	return fmt.Sprintf("%#x", crc32(data))
+}
+
+// crc32 generates a 32 bit Crc, zero for data with a correct Crc appended.
+func crc32(data []byte) uint32 {
 	crc := initValue
 	tbl := mkTable()
 	for _, bite := range data {
 		crc = tbl[int(bite)^((crc>>twentyFour)&twoFiftyFive)] ^ ((crc << eight) & (initValue - twoFiftyFive))
 	}
-	return fmt.Sprintf("%#x", uint32(crc))
+	return uint32(crc)
+}
+
+// appendCrc32 appends the Crc of data to data.
+func appendCrc32(data []byte) []byte {
+	crc := crc32(data)
+	return append(data, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
 }
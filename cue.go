@@ -101,9 +101,9 @@ func (cue *Cue) Show() {
 	fmt.Println(mkJson(&cue))
 }
 
-// AdjustPts adds seconds to cue.InfoSection.PtsAdjustment
+// AdjustPts adds seconds to cue.InfoSection.PtsAdjustment, wrapping at 33 bits
 func (cue *Cue) AdjustPts(seconds float64) {
-	cue.InfoSection.PtsAdjustment += seconds
+	cue.InfoSection.PtsAdjustment = NewPts(cue.InfoSection.PtsAdjustment).Add(seconds).Seconds()
 	cue.Encode()
 }
 
@@ -170,11 +170,14 @@ func (cue *Cue) mkSpliceInsert() {
 	 	SegmentationTypeIds to trigger CUE-OUTs : 0x22, 0x30, 0x32, 0x34, 0x36, 0x44, 0x46
 		SegmentationTypeIds to trigger CUE-INs:  0x23, 0x31, 0x33, 0x35, 0x37, 0x45, 0x47
 
+		Six2Five changes the Cue, use Cue.ToSpliceInserts to keep it.
+
 *
 */
 func (cue *Cue) Six2Five() string {
-	segStarts := []uint16{0x22, 0x30, 0x32, 0x34, 0x36, 0x44, 0x46}
-	segStops := []uint16{0x23, 0x31, 0x33, 0x35, 0x37, 0x45, 0x47}
+	rules := NewSix2FiveRules()
+	segStarts := rules.Outs
+	segStops := rules.Ins
 	if cue.InfoSection.CommandType == 6 {
 		for _, dscptr := range cue.Descriptors {
 			if dscptr.Tag == 2 {
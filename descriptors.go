@@ -295,7 +295,7 @@ func (dscptr *Descriptor) encodeSegmentation(be *bitEncoder) {
 	be.Add(dscptr.SegmentationUpidType, 8)
 	be.Add(dscptr.SegmentationUpidLength, 8)
 	if dscptr.SegmentationUpidLength > 0 {
-		dscptr.SegmentationUpid.encode(be, dscptr.SegmentationUpidType)
+		dscptr.SegmentationUpid.encode(be, dscptr.SegmentationUpidLength)
 	}
 	be.Add(dscptr.SegmentationTypeID, 8)
 	dscptr.encodeSegments(be)
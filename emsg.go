@@ -0,0 +1,270 @@
+package cuei
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Scte35Scheme is the emsg scheme_id_uri for binary SCTE-35.
+const Scte35Scheme = "urn:scte:scte35:2013:bin"
+
+// boxHeadSz is the size of an ISOBMFF box header in bytes.
+const boxHeadSz = 8
+
+/*
+Emsg is an ISOBMFF Event Message box carrying a SCTE-35 Cue.
+
+Version 0 boxes use PresentationTimeDelta,
+version 1 boxes use PresentationTime.
+*/
+type Emsg struct {
+	Version               uint8
+	Flags                 uint32
+	SchemeIDURI           string
+	Value                 string
+	Timescale             uint32
+	PresentationTime      uint64 `json:",omitempty"`
+	PresentationTimeDelta uint32 `json:",omitempty"`
+	EventDuration         uint32
+	ID                    uint32
+	Cue                   *Cue
+}
+
+/*
+Decode parses an emsg box, header included, and decodes the Cue in message_data.
+Decode returns false if the box isn't a SCTE-35 emsg box or is truncated.
+*/
+func (emsg *Emsg) Decode(box []byte) bool {
+	if len(box) < boxHeadSz || string(box[4:8]) != "emsg" {
+		return false
+	}
+	hlen := boxHeadSz
+	if binary.BigEndian.Uint32(box[0:4]) == 1 {
+		// largesize follows the box type
+		hlen += 8
+	}
+	if len(box) < hlen+4 {
+		return false
+	}
+	data := box[hlen:]
+	emsg.Version = data[0]
+	emsg.Flags = uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+	data = data[4:]
+	var ok bool
+	switch emsg.Version {
+	case 0:
+		if emsg.SchemeIDURI, data, ok = cString(data); !ok {
+			return false
+		}
+		if emsg.Value, data, ok = cString(data); !ok {
+			return false
+		}
+		if len(data) < 16 {
+			return false
+		}
+		emsg.Timescale = binary.BigEndian.Uint32(data[0:4])
+		emsg.PresentationTimeDelta = binary.BigEndian.Uint32(data[4:8])
+		emsg.EventDuration = binary.BigEndian.Uint32(data[8:12])
+		emsg.ID = binary.BigEndian.Uint32(data[12:16])
+		data = data[16:]
+	case 1:
+		if len(data) < 20 {
+			return false
+		}
+		emsg.Timescale = binary.BigEndian.Uint32(data[0:4])
+		emsg.PresentationTime = binary.BigEndian.Uint64(data[4:12])
+		emsg.EventDuration = binary.BigEndian.Uint32(data[12:16])
+		emsg.ID = binary.BigEndian.Uint32(data[16:20])
+		data = data[20:]
+		if emsg.SchemeIDURI, data, ok = cString(data); !ok {
+			return false
+		}
+		if emsg.Value, data, ok = cString(data); !ok {
+			return false
+		}
+	default:
+		return false
+	}
+	if emsg.SchemeIDURI != Scte35Scheme || !wholeSection(data) {
+		return false
+	}
+	emsg.Cue = NewCue()
+	return emsg.Cue.Decode(data)
+}
+
+// Encode returns the emsg box as bytes, header included.
+func (emsg *Emsg) Encode() []byte {
+	if emsg.SchemeIDURI == "" {
+		emsg.SchemeIDURI = Scte35Scheme
+	}
+	var body bytes.Buffer
+	body.WriteByte(emsg.Version)
+	body.Write([]byte{byte(emsg.Flags >> 16), byte(emsg.Flags >> 8), byte(emsg.Flags)})
+	switch emsg.Version {
+	case 0:
+		body.WriteString(emsg.SchemeIDURI + "\x00")
+		body.WriteString(emsg.Value + "\x00")
+		binary.Write(&body, binary.BigEndian, emsg.Timescale)
+		binary.Write(&body, binary.BigEndian, emsg.PresentationTimeDelta)
+		binary.Write(&body, binary.BigEndian, emsg.EventDuration)
+		binary.Write(&body, binary.BigEndian, emsg.ID)
+	default:
+		binary.Write(&body, binary.BigEndian, emsg.Timescale)
+		binary.Write(&body, binary.BigEndian, emsg.PresentationTime)
+		binary.Write(&body, binary.BigEndian, emsg.EventDuration)
+		binary.Write(&body, binary.BigEndian, emsg.ID)
+		body.WriteString(emsg.SchemeIDURI + "\x00")
+		body.WriteString(emsg.Value + "\x00")
+	}
+	if emsg.Cue != nil {
+		body.Write(emsg.Cue.Encode())
+	}
+	box := make([]byte, boxHeadSz, boxHeadSz+body.Len())
+	binary.BigEndian.PutUint32(box[0:4], uint32(boxHeadSz+body.Len()))
+	copy(box[4:8], "emsg")
+	return append(box, body.Bytes()...)
+}
+
+// Json returns the Emsg as JSON
+func (emsg *Emsg) Json() string {
+	return mkJson(emsg)
+}
+
+// Show prints the Emsg as JSON
+func (emsg *Emsg) Show() {
+	fmt.Println(emsg.Json())
+}
+
+/*
+Encode2Emsg encodes the cue in a version 1 emsg box.
+
+presentationTime and the event duration are in timescale units,
+the event duration comes from the Cue's break or segmentation duration,
+0xFFFFFFFF when neither is set.
+*/
+func (cue *Cue) Encode2Emsg(timescale uint32, presentationTime uint64, id uint32) []byte {
+	emsg := &Emsg{
+		Version:          1,
+		SchemeIDURI:      Scte35Scheme,
+		Timescale:        timescale,
+		PresentationTime: presentationTime,
+		EventDuration:    0xffffffff,
+		ID:               id,
+		Cue:              cue,
+	}
+	if secs := cue.duration(); secs > 0 {
+		emsg.EventDuration = uint32(math.Round(secs * float64(timescale)))
+	}
+	return emsg.Encode()
+}
+
+// duration returns the break or segmentation duration in seconds.
+func (cue *Cue) duration() float64 {
+	if cue.Command != nil && cue.Command.DurationFlag {
+		return cue.Command.BreakDuration
+	}
+	for _, dscptr := range cue.Descriptors {
+		if dscptr.Tag == 2 && dscptr.SegmentationDurationFlag {
+			return dscptr.SegmentationDuration
+		}
+	}
+	return 0
+}
+
+// DecodeEmsg finds SCTE-35 emsg boxes in fname, an fMP4 file.
+func DecodeEmsg(fname string) []*Emsg {
+	file, err := os.Open(fname)
+	chk(err)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	emsgs, err := DecodeEmsgReader(file)
+	chk(err)
+	return emsgs
+}
+
+/*
+DecodeEmsgReader walks the top level ISOBMFF boxes in rdr
+and returns the SCTE-35 emsg boxes it finds.
+Other boxes are skipped without being read into memory.
+
+A truncated box returns the emsg boxes before it and io.ErrUnexpectedEOF,
+a box size smaller than its header returns an error too.
+*/
+func DecodeEmsgReader(rdr io.Reader) ([]*Emsg, error) {
+	var emsgs []*Emsg
+	head := make([]byte, boxHeadSz)
+	for {
+		_, err := io.ReadFull(rdr, head)
+		if err == io.EOF {
+			return emsgs, nil
+		}
+		if err != nil {
+			return emsgs, err
+		}
+		size := uint64(binary.BigEndian.Uint32(head[0:4]))
+		hlen := uint64(boxHeadSz)
+		if size == 1 {
+			large := make([]byte, 8)
+			if _, err = io.ReadFull(rdr, large); err != nil {
+				return emsgs, io.ErrUnexpectedEOF
+			}
+			size = binary.BigEndian.Uint64(large)
+			hlen += 8
+		}
+		if size != 0 && size < hlen {
+			return emsgs, fmt.Errorf("%q box size %d is smaller than its header", head[4:8], size)
+		}
+		if string(head[4:8]) != "emsg" {
+			if size == 0 {
+				// box runs to the end of the file
+				return emsgs, nil
+			}
+			if _, err = io.CopyN(io.Discard, rdr, int64(size-hlen)); err != nil {
+				return emsgs, io.ErrUnexpectedEOF
+			}
+			continue
+		}
+		var body []byte
+		if size == 0 {
+			body, err = io.ReadAll(rdr)
+		} else {
+			body = make([]byte, size-hlen)
+			if _, err = io.ReadFull(rdr, body); err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		if err != nil {
+			return emsgs, err
+		}
+		box := make([]byte, boxHeadSz, boxHeadSz+len(body))
+		binary.BigEndian.PutUint32(box[0:4], uint32(boxHeadSz+len(body)))
+		copy(box[4:8], "emsg")
+		emsg := &Emsg{}
+		if emsg.Decode(append(box, body...)) {
+			emsgs = append(emsgs, emsg)
+		}
+	}
+}
+
+// wholeSection is true if data starts with a whole splice_info_section.
+func wholeSection(data []byte) bool {
+	if len(data) < 3 {
+		return false
+	}
+	return 3+int(parseLen(data[1], data[2])) <= len(data)
+}
+
+// cString splits a null terminated string from data.
+func cString(data []byte) (string, []byte, bool) {
+	idx := bytes.IndexByte(data, 0)
+	if idx == -1 {
+		return "", data, false
+	}
+	return string(data[:idx]), data[idx+1:], true
+}
@@ -0,0 +1,135 @@
+package cuei
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// isoBox returns a box with a 32 bit size, or a size of zero when open is true.
+func isoBox(typ string, body []byte, open bool) []byte {
+	box := make([]byte, boxHeadSz)
+	if !open {
+		binary.BigEndian.PutUint32(box[0:4], uint32(boxHeadSz+len(body)))
+	}
+	copy(box[4:8], typ)
+	return append(box, body...)
+}
+
+// largeBox returns a box with a size of 1 and a 64 bit largesize.
+func largeBox(typ string, body []byte) []byte {
+	box := make([]byte, boxHeadSz+8)
+	binary.BigEndian.PutUint32(box[0:4], 1)
+	copy(box[4:8], typ)
+	binary.BigEndian.PutUint64(box[8:16], uint64(len(box)+len(body)))
+	return append(box, body...)
+}
+
+// testEmsg returns a version 1 emsg box for testCue with id.
+func testEmsg(id uint32) []byte {
+	cue := NewCue()
+	cue.Decode(testCue)
+	return cue.Encode2Emsg(90000, 900000, id)
+}
+
+func TestEmsgV0(t *testing.T) {
+	cue := NewCue()
+	cue.Decode(testCue)
+	in := &Emsg{
+		Version:               0,
+		Flags:                 0x000102,
+		Value:                 "1",
+		Timescale:             1000,
+		PresentationTimeDelta: 500,
+		EventDuration:         0xffffffff,
+		ID:                    7,
+		Cue:                   cue,
+	}
+	box := in.Encode()
+	emsg := &Emsg{}
+	if !emsg.Decode(box) {
+		t.Fatal("version 0 box didn't decode")
+	}
+	if emsg.Version != 0 || emsg.Flags != 0x000102 || emsg.SchemeIDURI != Scte35Scheme || emsg.Value != "1" {
+		t.Fatalf("header is %+v", emsg)
+	}
+	if emsg.Timescale != 1000 || emsg.PresentationTimeDelta != 500 || emsg.PresentationTime != 0 {
+		t.Fatalf("times are %d %d %d", emsg.Timescale, emsg.PresentationTimeDelta, emsg.PresentationTime)
+	}
+	if emsg.EventDuration != 0xffffffff || emsg.ID != 7 {
+		t.Fatalf("duration %d, id %d", emsg.EventDuration, emsg.ID)
+	}
+	if emsg.Cue.Encode2B64() != testCue {
+		t.Fatalf("Cue is %v", emsg.Cue.Encode2B64())
+	}
+}
+
+func TestDecodeEmsgReader(t *testing.T) {
+	emsg1, emsg2 := testEmsg(1), testEmsg(2)
+	other := &Emsg{Version: 1, SchemeIDURI: "urn:mpeg:dash:event:2012", Value: "1", ID: 3}
+	tests := []struct {
+		name string
+		mp4  []byte
+		ids  []uint32
+	}{
+		{"one box", emsg1, []uint32{1}},
+		{"between other boxes", cat(isoBox("styp", make([]byte, 12), false), emsg1, isoBox("moof", make([]byte, 500), false), emsg2), []uint32{1, 2}},
+		{"largesize emsg", largeBox("emsg", emsg1[boxHeadSz:]), []uint32{1}},
+		{"largesize box skipped", cat(largeBox("mdat", make([]byte, 300)), emsg2), []uint32{2}},
+		{"size zero emsg", cat(emsg1, isoBox("emsg", emsg2[boxHeadSz:], true)), []uint32{1, 2}},
+		{"size zero box ends the file", cat(emsg1, isoBox("mdat", emsg2, true)), []uint32{1}},
+		{"other scheme skipped", cat(other.Encode(), emsg2), []uint32{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emsgs, err := DecodeEmsgReader(bytes.NewReader(tt.mp4))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []uint32
+			for _, emsg := range emsgs {
+				ids = append(ids, emsg.ID)
+				if emsg.Cue.Encode2B64() != testCue {
+					t.Fatalf("box %d Cue is %v", emsg.ID, emsg.Cue.Encode2B64())
+				}
+			}
+			if len(ids) != len(tt.ids) {
+				t.Fatalf("got ids %v, want %v", ids, tt.ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.ids[i] {
+					t.Fatalf("got ids %v, want %v", ids, tt.ids)
+				}
+			}
+		})
+	}
+}
+
+func TestEmsgTruncated(t *testing.T) {
+	box := testEmsg(1)
+	mp4 := cat(testEmsg(9), box)
+	for i := 1; i < len(box); i++ {
+		if (&Emsg{}).Decode(box[:i]) {
+			t.Fatalf("box cut to %d bytes decoded", i)
+		}
+		emsgs, err := DecodeEmsgReader(bytes.NewReader(mp4[:len(mp4)-len(box)+i]))
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("box cut to %d bytes: error is %v", i, err)
+		}
+		if len(emsgs) != 1 || emsgs[0].ID != 9 {
+			t.Fatalf("box cut to %d bytes: got %d boxes", i, len(emsgs))
+		}
+	}
+	// the box size is right, message_data is cut short
+	short := isoBox("emsg", box[boxHeadSz:len(box)-4], false)
+	if emsgs, err := DecodeEmsgReader(bytes.NewReader(short)); err != nil || len(emsgs) != 0 {
+		t.Fatalf("short message_data: got %d boxes, error %v", len(emsgs), err)
+	}
+	// a box size smaller than the header
+	bad := isoBox("free", nil, false)
+	binary.BigEndian.PutUint32(bad[0:4], 4)
+	if _, err := DecodeEmsgReader(bytes.NewReader(cat(bad, box))); err == nil {
+		t.Fatal("box size 4 didn't return an error")
+	}
+}
@@ -1,8 +1,11 @@
 package cuei_test
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/futzu/cuei"
+	"io"
+	"os"
 	"testing"
 )
 
@@ -51,7 +54,7 @@ func ExampleJson2Cue() {
 }
 `
 	cue := cuei.Json2Cue(js)
-    cue.Show()
+	cue.Show()
 }
 
 func ExampleNewCue() {
@@ -157,3 +160,187 @@ func Test(t *testing.T) {
 	})
 
 }
+
+func ExampleCue_Encode2Emsg() {
+	data := "/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo="
+	cue := cuei.NewCue()
+	cue.Decode(data)
+	// encode the cue in a version 1 emsg box
+	box := cue.Encode2Emsg(90000, 174123456, 1)
+	emsgs, _ := cuei.DecodeEmsgReader(bytes.NewReader(box))
+	for _, emsg := range emsgs {
+		fmt.Println(emsg.Timescale, emsg.PresentationTime, emsg.EventDuration, emsg.ID)
+		fmt.Println(emsg.Cue.Encode2B64())
+	}
+	// Output:
+	// 90000 174123456 5426421 1
+	// /DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo=
+}
+
+func ExampleCue_ToSpliceInserts() {
+	data := "/DCtAAAAAAAAAP/wBQb+Tq9DwQCXAixDVUVJCUvhcH+fAR1QQ1IxXzEyMTYyMTE0MDBXQUJDUkFDSEFFTFJBWSEBAQIsQ1VFSQlL4W9/nwEdUENSMV8xMjE2MjExNDAwV0FCQ1JBQ0hBRUxSQVkRAQECGUNVRUkJTBwVf58BClRLUlIxNjA4NEEQAQECHkNVRUkJTBwWf98AA3clYAEKVEtSUjE2MDg0QSABAdHBXYA="
+	cue := cuei.NewCue()
+	cue.Decode(data)
+	rules := cuei.NewSix2FiveRules()
+	// also treat Program Start and Program End as CUE-OUT and CUE-IN
+	rules.Outs = append(rules.Outs, 0x10)
+	rules.Ins = append(rules.Ins, 0x11)
+	fives, unmapped := cue.ToSpliceInserts(rules)
+	for _, five := range fives {
+		fmt.Println(five.Command.SpliceEventID, five.Command.OutOfNetworkIndicator, five.Encode2B64())
+	}
+	for _, dscptr := range unmapped {
+		fmt.Println("unmapped:", dscptr.SegmentationMessage)
+	}
+	// Output:
+	// 155967855 false /DBOAAAAAAAAAP/wDwUJS+Fvf0/+Tq9DwQAAAQEALgIsQ1VFSQlL4W9/nwEdUENSMV8xMjE2MjExNDAwV0FCQ1JBQ0hBRUxSQVkRAQF5aU6r
+	// 155982869 true /DA7AAAAAAAAAP/wDwUJTBwVf8/+Tq9DwQAAAQEAGwIZQ1VFSQlMHBV/nwEKVEtSUjE2MDg0QRABAcxabj0=
+	// unmapped: Chapter End
+	// unmapped: Chapter Start
+}
+
+func ExampleCue_ToTimeSignal() {
+	data := "/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo="
+	cue := cuei.NewCue()
+	cue.Decode(data)
+	// use Distributor Placement Opportunities
+	rules := &cuei.Five2SixRules{Out: 0x36, In: 0x37}
+	six := cue.ToTimeSignal(rules)
+	fmt.Println(six.Encode2B64())
+	// Output:
+	// /DA4AAAAAAAA///wBQb+c2nALgAiAAhDVUVJAAABNQIWQ1VFSUgAAI9//wAAUsz1AAA2AAAAADQRvUc=
+}
+
+func ExampleTracker() {
+	tracker := cuei.NewTracker()
+	out := cuei.NewCue()
+	// Splice Insert CUE-OUT, 60.293566 second break with auto return
+	out.Decode("/DAvAAAAAAAA///wFAVIAACPf+/+c2nALv4AUsz1AAAAAAAKAAhDVUVJAAABNWLbowo=")
+	for _, bev := range tracker.Add(out) {
+		fmt.Println(bev.Type, bev.Pts, bev.Break.EventID)
+	}
+	// Nothing happens until the break is over
+	fmt.Println(len(tracker.Tick(0, 21544.0)))
+	for _, bev := range tracker.Tick(0, 21575.0) {
+		fmt.Printf("%v %.6f %v\n", bev.Type, bev.Pts, bev.Auto)
+	}
+	// Output:
+	// Break Started 21514.559088 1207959695
+	// 0
+	// Break Ended 21574.852655 true
+}
+
+func ExampleUpid_Validate() {
+	upid := &cuei.Upid{UpidType: 0x0a, Value: "10.5240/7791-8534-2C23-9030-8610-5"}
+	fmt.Println(upid.Validate())
+	upid.Value = "10.5240/7791-8534-2C23-9030-8610-6"
+	fmt.Println(upid.Validate())
+	// Output:
+	// <nil>
+	// bad EIDR check character "10.5240/7791-8534-2C23-9030-8610-6"
+}
+
+func ExamplePts() {
+	start := cuei.NewPts(95440.0) // a few seconds before the rollover
+	end := start.Add(30.0)
+	fmt.Println(end.Seconds())
+	fmt.Println(end.Sub(start), end.After(start))
+	// Output:
+	// 26.282311
+	// 30 true
+}
+
+func ExampleStream_Handle() {
+	stream := cuei.NewStream()
+	stream.Quiet = true
+	// called with each Cue as soon as it is found
+	stream.Handle(func(cue *cuei.Cue) {
+		fmt.Printf("%v, %v\n", cue.PacketData.Pts, cue.Encode2B64())
+	})
+	stream.Decode("video.ts")
+}
+
+func ExampleStream_CueChan() {
+	stream := cuei.NewStream()
+	stream.Quiet = true
+	cues := stream.CueChan(10)
+	go stream.Decode("udp://@235.35.3.5:3535")
+	for cue := range cues {
+		fmt.Printf("%v, %v\n", cue.PacketData.Pts, cue.Command.Name)
+	}
+}
+
+func ExampleMuxer() {
+	in, err := os.Open("video.ts")
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.Create("video-with-scte35.ts")
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	mux := cuei.NewMuxer(out)
+	cue := cuei.NewCue()
+	cue.Decode("/DAWAAAAAAAAAP/wBQb+AKmKxwAACzuu2Q==")
+	// written 4 seconds ahead of the splice time
+	mux.Insert(cue)
+	io.Copy(mux, in)
+	mux.Flush()
+}
+
+func ExampleCue_Encode2Packets() {
+	cue := cuei.NewCue()
+	cue.Decode("/DAWAAAAAAAAAP/wBQb+AKmKxwAACzuu2Q==")
+	pkts, cc := cue.Encode2Packets(0x86, 0)
+	for _, pkt := range pkts {
+		fmt.Printf("%v %x\n", len(pkt), pkt[:8])
+	}
+	fmt.Println("next cc", cc)
+	// Output:
+	// 188 4740861000fc3016
+	// next cc 1
+}
+
+func ExampleFilter() {
+	in, err := os.Open("video.ts")
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.Create("video-no-ads.ts")
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	flt := cuei.NewFilter(out)
+	// drop Provider Advertisements, keep other Cues
+	flt.Keep = func(cue *cuei.Cue) bool {
+		for _, dscptr := range cue.Descriptors {
+			if dscptr.SegmentationTypeID == 0x30 || dscptr.SegmentationTypeID == 0x31 {
+				return false
+			}
+		}
+		return true
+	}
+	io.Copy(flt, in)
+	flt.Flush()
+}
+
+func ExampleRestamper() {
+	in, err := os.Open("video.ts")
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	out, err := os.Create("video-shifted.ts")
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	// video.ts was time shifted by 10 seconds
+	rs := cuei.NewRestamper(out, 10.0)
+	io.Copy(rs, in)
+	rs.Flush()
+}
@@ -0,0 +1,176 @@
+package cuei
+
+import (
+	"bytes"
+	"io"
+)
+
+/*
+Filter strips SCTE-35 from MPEGTS.
+
+MPEGTS written to a Filter is passed through to the io.Writer
+given to NewFilter, without the SCTE-35 PIDs in Stream.Pids.Scte35Pids.
+The PMT is rewritten without their stream entries,
+with a new CRC_32 and the version number incremented.
+
+Set Filter.Keep to keep the SCTE-35 PIDs and drop only some Cues.
+Cues are kept when Keep returns true, the rest are dropped.
+Kept Cues are written as they came, as sections or in PES packets.
+Filter.CueStreamCheck drops or flags Cues not allowed by cue_stream_type
+before they're passed to Keep, like Stream.CueStreamCheck.
+188, 192 and 204 byte packets are found like Stream.DecodeBytes does.
+*/
+type Filter struct {
+	Keep           func(*Cue) bool // Keep selects Cues to keep, nil drops all SCTE-35.
+	CueStreamCheck int             // CueStreamCheck is CueStreamIgnore, CueStreamFlag or CueStreamReject, for Cues passed to Keep.
+	stream         *Stream
+	pw             *pktWriter
+	pmts           partials     // pmts are PMT sections spread across packets
+	cues           *cueRewriter // cues re-packetizes kept Cues
+	ccs            counters     // ccs are continuity counters for rewritten PIDs
+}
+
+// NewFilter initializes and returns a *Filter writing to wr.
+func NewFilter(wr io.Writer) *Filter {
+	flt := &Filter{}
+	flt.stream = NewStream()
+	flt.stream.Quiet = true
+	flt.stream.Handle(func(*Cue) {})
+	flt.pw = newPktWriter(flt.stream, wr, flt.filter)
+	flt.pmts = make(partials)
+	flt.ccs = make(counters)
+	flt.cues = newCueRewriter(flt.stream, flt.ccs)
+	return flt
+}
+
+/*
+Write filters the MPEGTS in p, so a Filter can be used with io.Copy.
+p doesn't need to end on a packet boundary.
+*/
+func (flt *Filter) Write(p []byte) (int, error) {
+	return flt.pw.Write(p)
+}
+
+// Flush writes any partial packet left from Write.
+func (flt *Filter) Flush() error {
+	return flt.pw.Flush()
+}
+
+// filter parses pkt and returns what to write for it.
+func (flt *Filter) filter(pkt []byte) []byte {
+	flt.stream.CueStreamCheck = flt.CueStreamCheck
+	flt.stream.parse(pkt)
+	pid := parsePid(pkt[1], pkt[2])
+	switch {
+	case flt.stream.Pids.isScte35Pid(pid):
+		if flt.Keep == nil {
+			return nil
+		}
+		flt.ccs.seed(pkt, pid)
+		return flt.keepCues(pkt, pid)
+	case flt.Keep == nil && flt.stream.Pids.isPmtPid(pid):
+		flt.ccs.seed(pkt, pid)
+		return flt.rewritePmt(pkt, pid)
+	}
+	return pkt
+}
+
+// keepCues returns packets for the Cues in pkt that Filter.Keep selects.
+func (flt *Filter) keepCues(pkt []byte, pid uint16) []byte {
+	return flt.cues.rewrite(pkt, pid, func(cue *Cue, sec []byte) []byte {
+		if flt.Keep(cue) {
+			return sec
+		}
+		return nil
+	})
+}
+
+// cueRewriter re-packetizes SCTE-35 sections on their PIDs.
+type cueRewriter struct {
+	stream *Stream
+	parts  partials        // parts are SCTE-35 sections spread across packets
+	ccs    counters        // ccs are continuity counters for rewritten PIDs
+	pes    map[uint16]bool // pes is true for PIDs with SCTE-35 in PES packets
+}
+
+// newCueRewriter returns a *cueRewriter for stream, using the continuity counters in ccs.
+func newCueRewriter(stream *Stream, ccs counters) *cueRewriter {
+	return &cueRewriter{stream: stream, parts: make(partials), ccs: ccs, pes: make(map[uint16]bool)}
+}
+
+/*
+rewrite collects SCTE-35 sections in pkt and passes each Cue,
+with PacketData, and its section to fn. Cues rejected by
+Stream.CueStreamCheck are dropped. The sections fn returns
+are packetized on pid with the next continuity counters,
+in PES packets if that's how they came.
+*/
+func (cr *cueRewriter) rewrite(pkt []byte, pid uint16, fn func(*Cue, []byte) []byte) []byte {
+	pay := cr.stream.parsePayload(pkt)
+	pusi := cr.stream.parsePusi(pkt)
+	if pusi {
+		cr.pes[pid] = bytes.HasPrefix(pay, pesStart)
+	}
+	var out []byte
+	for _, sec := range cr.parts.sections(pay, pid, pusi) {
+		cue := cr.stream.mkCue(pid)
+		if sec[0] != 0xfc || !cue.Decode(sec) {
+			continue
+		}
+		cue.spliceTiming()
+		if !cr.stream.checkCueStream(cue, pid) {
+			continue
+		}
+		if sec = fn(cue, sec); len(sec) == 0 {
+			continue
+		}
+		if cr.pes[pid] {
+			out = append(out, cr.ccs.packetizePes(sec, pid)...)
+		} else {
+			out = append(out, cr.ccs.packetize(sec, pid)...)
+		}
+	}
+	return out
+}
+
+// rewritePmt returns PMT sections on pid as packets, once they're complete, without SCTE-35.
+func (flt *Filter) rewritePmt(pkt []byte, pid uint16) []byte {
+	pay := flt.stream.parsePayload(pkt)
+	var out []byte
+	for _, sec := range flt.pmts.sections(pay, pid, flt.stream.parsePusi(pkt)) {
+		out = append(out, flt.ccs.packetize(flt.dropScte35(sec), pid)...)
+	}
+	return out
+}
+
+// dropScte35 removes SCTE-35 stream entries from a PMT section.
+func (flt *Filter) dropScte35(sec []byte) []byte {
+	if sec[0] != 0x02 || len(sec) < 16 || !crcOk(sec) {
+		return sec
+	}
+	end := len(sec) - 4
+	idx := 12 + int(parseLen(sec[10], sec[11]))
+	if idx > end {
+		return sec
+	}
+	body := append([]byte(nil), sec[:idx]...)
+	dropped := false
+	for idx+5 <= end {
+		elpid := parsePid(sec[idx+1], sec[idx+2])
+		next := idx + 5 + int(parseLen(sec[idx+3], sec[idx+4]))
+		if next > end {
+			next = end
+		}
+		if flt.stream.Pids.isScte35Pid(elpid) {
+			dropped = true
+		} else {
+			body = append(body, sec[idx:next]...)
+		}
+		idx = next
+	}
+	if !dropped {
+		return sec
+	}
+	bumpVersion(body)
+	return finishSection(body)
+}
@@ -0,0 +1,113 @@
+package cuei
+
+import (
+	"bytes"
+	"testing"
+)
+
+// dtmfCue is a Splice Insert with a DTMF Descriptor.
+const dtmfCue = "/DAsAAAAAAAAAP/wDwUAAABef0/+zPACTQAAAAAADAEKQ1VFSbGfMTIxIxGolm0="
+
+// filterTs writes ts through flt in odd sized writes and returns the output.
+func filterTs(t *testing.T, flt *Filter, out *bytes.Buffer, ts []byte) []byte {
+	t.Helper()
+	writeChunks(t, flt, ts, 1000)
+	if err := flt.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// decodeTs returns the Cues in ts and the Stream that decoded them.
+func decodeTs(ts []byte) ([]*Cue, *Stream) {
+	stream := NewStream()
+	stream.Quiet = true
+	return stream.DecodeBytes(ts), stream
+}
+
+func TestFilterStrip(t *testing.T) {
+	var out bytes.Buffer
+	got := filterTs(t, NewFilter(&out), &out, testTs(testCues(3)...))
+	cues, stream := decodeTs(got)
+	if len(cues) != 0 || stream.Pid2Stats[testScte35Pid] != nil {
+		t.Fatalf("got %d Cues, SCTE-35 PID stats %+v", len(cues), stream.Pid2Stats[testScte35Pid])
+	}
+	// the PMT without the SCTE-35 stream, version 0 to 1
+	if _, ok := stream.Pid2Type[testScte35Pid]; ok || stream.Pid2Type[testVidPid] != 0x1b {
+		t.Fatalf("PMT streams are %v", stream.Pid2Type)
+	}
+	if versions := psiVersions(got, testPmtPid); !bytes.Equal(versions, []uint8{1}) {
+		t.Fatalf("PMT versions are %v, want [1]", versions)
+	}
+	if len(got) != 2*pktSz {
+		t.Fatalf("got %d bytes, want the PAT and PMT", len(got))
+	}
+}
+
+func TestFilterKeep(t *testing.T) {
+	insert := decB64(dtmfCue)
+	ts := testTs(decB64(testCue), insert, decB64(testCue), insert)
+	var out bytes.Buffer
+	flt := NewFilter(&out)
+	// drop Splice Inserts
+	flt.Keep = func(cue *Cue) bool { return cue.Command.CommandType != 5 }
+	cues, stream := decodeTs(filterTs(t, flt, &out, ts))
+	if len(cues) != 2 || cues[0].Command.CommandType != 6 || cues[1].Command.CommandType != 6 {
+		t.Fatalf("got %d Cues", len(cues))
+	}
+	// the PMT is unchanged, the continuity counters of kept Cues count up
+	if _, ok := stream.Pid2Type[testScte35Pid]; !ok {
+		t.Fatal("SCTE-35 stream was removed from the PMT")
+	}
+	if stats := stream.Pid2Stats[testScte35Pid]; stats.CcErrors != 0 || stats.Packets != 2 {
+		t.Fatalf("stats are %+v", stats)
+	}
+}
+
+func TestFilterKeepPes(t *testing.T) {
+	cue := NewCue()
+	cue.Decode(testCue)
+	ts := testTs()
+	cc := uint8(0)
+	for i := 0; i < 3; i++ {
+		var pkts [][]byte
+		pkts, cc = cue.Encode2PesPackets(testScte35Pid, cc)
+		ts = append(ts, cat(pkts...)...)
+	}
+	var out bytes.Buffer
+	flt := NewFilter(&out)
+	flt.Keep = func(*Cue) bool { return true }
+	if got := filterTs(t, flt, &out, ts); !bytes.Equal(got, ts) {
+		t.Fatal("PES packets weren't kept as they came")
+	}
+}
+
+func TestFilterCueStreamCheck(t *testing.T) {
+	// cue_stream_type 0x02, segmentation and time_signal only
+	ts := cueStreamTs([]byte{0x8a, 0x01, 0x02}, decB64(testCue), decB64(dtmfCue))
+	tests := []struct {
+		check   int
+		kept    int
+		flagged int
+	}{
+		{CueStreamIgnore, 2, 0},
+		{CueStreamFlag, 2, 1},
+		{CueStreamReject, 1, 0},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		flt := NewFilter(&out)
+		flt.CueStreamCheck = tt.check
+		flagged := 0
+		flt.Keep = func(cue *Cue) bool {
+			if cue.PacketData.CueStreamTypeError {
+				flagged++
+			}
+			return true
+		}
+		cues, _ := decodeTs(filterTs(t, flt, &out, ts))
+		if len(cues) != tt.kept || flagged != tt.flagged {
+			t.Fatalf("CueStreamCheck %d: kept %d, flagged %d, want %d, %d", tt.check, len(cues), flagged, tt.kept, tt.flagged)
+		}
+	}
+}
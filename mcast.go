@@ -0,0 +1,188 @@
+package cuei
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// maxDgram is the largest UDP datagram.
+const maxDgram = 65535
+
+// rcvBuf is the default socket receive buffer size for multicast.
+const rcvBuf = 1316 * 70000
+
+// fecDepth is the default RTP depth when FEC is used.
+const fecDepth = 256
+
+/*
+mcastOpts holds multicast settings from a url like
+
+	udp://@235.35.3.5:3535
+	udp://10.0.0.7@232.1.1.1:5000?iface=eth1&pkt=1316&rcvbuf=8388608
+	udp://@235.35.3.5:3535?fec=1&depth=200
+
+	source@   source specific multicast sender, Linux only
+	iface     network interface to join the group on
+	pkt       datagram size in bytes, defaults to the largest UDP datagram
+	rcvbuf    socket receive buffer size in bytes
+	fec       1 to join SMPTE 2022-1 FEC columns and rows on port + 2 and port + 4
+	depth     RTP packets held for reordering and FEC, defaults to 256 with fec
+*/
+type mcastOpts struct {
+	group  *net.UDPAddr
+	source net.IP
+	iface  *net.Interface
+	dgram  int
+	rcvbuf int
+	fec    bool
+	depth  int
+}
+
+// isMcast is true for udp://@group:port and udp://source@group:port urls.
+func isMcast(source string) bool {
+	if strings.HasPrefix(source, mcastPrefix) {
+		return true
+	}
+	if !strings.HasPrefix(source, "udp://") {
+		return false
+	}
+	host := strings.TrimPrefix(source, "udp://")
+	if idx := strings.IndexAny(host, "/?"); idx != -1 {
+		host = host[:idx]
+	}
+	return strings.Contains(host, "@")
+}
+
+// parseMcast parses a multicast url into mcastOpts.
+func parseMcast(source string) (*mcastOpts, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	opts := &mcastOpts{dgram: maxDgram, rcvbuf: rcvBuf}
+	if u.User != nil && u.User.Username() != "" {
+		opts.source = net.ParseIP(u.User.Username())
+		if opts.source == nil {
+			return nil, fmt.Errorf("bad multicast source address %q", u.User.Username())
+		}
+	}
+	opts.group, err = net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if !opts.group.IP.IsMulticast() {
+		return nil, fmt.Errorf("%v is not a multicast address", opts.group.IP)
+	}
+	query := u.Query()
+	if name := query.Get("iface"); name != "" {
+		opts.iface, err = net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.dgram, err = queryInt(query, "pkt", opts.dgram); err != nil {
+		return nil, err
+	}
+	if opts.rcvbuf, err = queryInt(query, "rcvbuf", opts.rcvbuf); err != nil {
+		return nil, err
+	}
+	opts.fec = query.Get("fec") == "1"
+	if opts.fec {
+		opts.depth = fecDepth
+	}
+	if opts.depth, err = queryInt(query, "depth", opts.depth); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// queryInt returns the positive int value of key in query, or dflt when key is absent.
+func queryInt(query url.Values, key string, dflt int) (int, error) {
+	val := query.Get(key)
+	if val == "" {
+		return dflt, nil
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("bad %v value %q", key, val)
+	}
+	return i, nil
+}
+
+// listenMcast joins the multicast group in opts.
+func listenMcast(opts *mcastOpts) (*net.UDPConn, error) {
+	var conn *net.UDPConn
+	var err error
+	if opts.source != nil {
+		conn, err = listenSsm(opts)
+	} else {
+		conn, err = net.ListenMulticastUDP("udp", opts.iface, opts.group)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = conn.SetReadBuffer(opts.rcvbuf)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// ifaceAddr4 returns the first IPv4 address of iface.
+func ifaceAddr4(iface *net.Interface) (net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("%v has no IPv4 address", iface.Name)
+}
+
+/*
+listenFec joins the FEC column and row streams for opts
+on port + 2 and port + 4 and passes their datagrams
+to the Stream's rtpReceiver. Call the returned func to leave them.
+*/
+func (stream *Stream) listenFec(opts *mcastOpts) (func(), error) {
+	rcvr := stream.rtpRcvr()
+	var conns []*net.UDPConn
+	leave := func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+	for _, offset := range []int{2, 4} {
+		fopts := *opts
+		group := *opts.group
+		group.Port += offset
+		fopts.group = &group
+		conn, err := listenMcast(&fopts)
+		if err != nil {
+			leave()
+			return nil, err
+		}
+		conns = append(conns, conn)
+		go func() {
+			buffer := make([]byte, maxDgram)
+			for {
+				n, _, err := conn.ReadFromUDP(buffer)
+				if err != nil {
+					return
+				}
+				_, pay, ok := rtpPayload(buffer[:n])
+				if ok {
+					rcvr.addFec(pay)
+				}
+			}
+		}()
+	}
+	return leave, nil
+}
@@ -0,0 +1,63 @@
+package cuei
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+/*
+listenSsm binds to the group in opts and joins it
+with IGMPv3 source filtering for opts.source.
+*/
+func listenSsm(opts *mcastOpts) (*net.UDPConn, error) {
+	group := opts.group.IP.To4()
+	source := opts.source.To4()
+	if group == nil || source == nil {
+		return nil, fmt.Errorf("source specific multicast needs IPv4 addresses")
+	}
+	ifaddr := net.IPv4zero.To4()
+	if opts.iface != nil {
+		var err error
+		ifaddr, err = ifaceAddr4(opts.iface)
+		if err != nil {
+			return nil, err
+		}
+	}
+	lc := net.ListenConfig{Control: func(network, address string, rc syscall.RawConn) error {
+		return sockopt(rc, func(fd int) error {
+			return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+		})
+	}}
+	pc, err := lc.ListenPacket(context.Background(), "udp4", opts.group.String())
+	if err != nil {
+		return nil, err
+	}
+	conn := pc.(*net.UDPConn)
+	rc, err := conn.SyscallConn()
+	if err == nil {
+		// struct ip_mreq_source is multiaddr, interface, sourceaddr
+		mreq := string(group) + string(ifaddr) + string(source)
+		err = sockopt(rc, func(fd int) error {
+			return syscall.SetsockoptString(fd, syscall.IPPROTO_IP, syscall.IP_ADD_SOURCE_MEMBERSHIP, mreq)
+		})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sockopt calls fn with the file descriptor of rc.
+func sockopt(rc syscall.RawConn, fn func(fd int) error) error {
+	var ferr error
+	err := rc.Control(func(fd uintptr) {
+		ferr = fn(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	return ferr
+}
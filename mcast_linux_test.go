@@ -0,0 +1,115 @@
+package cuei
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// mcastIface returns an interface that is up, can multicast and has an IPv4 address.
+func mcastIface(t *testing.T) (*net.Interface, net.IP) {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip(err)
+	}
+	for i := range ifaces {
+		iface := &ifaces[i]
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagMulticast == 0 {
+			continue
+		}
+		if addr, err := ifaceAddr4(iface); err == nil {
+			return iface, addr
+		}
+	}
+	t.Skip("no multicast interface")
+	return nil, nil
+}
+
+// mcastSender returns a socket bound to addr that sends multicast out of addr's interface.
+func mcastSender(t *testing.T, addr net.IP) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: addr})
+	if err != nil {
+		t.Skip(err)
+	}
+	rc, err := conn.SyscallConn()
+	if err == nil {
+		var ifaddr [4]byte
+		copy(ifaddr[:], addr.To4())
+		err = sockopt(rc, func(fd int) error {
+			return syscall.SetsockoptInet4Addr(fd, syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, ifaddr)
+		})
+	}
+	if err != nil {
+		conn.Close()
+		t.Skip(err)
+	}
+	return conn
+}
+
+// mcastLoopback decodes source while sending Cues to group, it skips when nothing arrives.
+func mcastLoopback(t *testing.T, source string, group *net.UDPAddr, addr net.IP) {
+	sender := mcastSender(t, addr)
+	defer sender.Close()
+	stream := NewStream()
+	stream.Quiet = true
+	found := make(chan *Cue, 10)
+	stream.Handle(func(cue *Cue) { found <- cue })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := stream.DecodeMulticastContext(ctx, source)
+		errc <- err
+	}()
+	ts := testTs(testCues(1)...)
+	deadline := time.After(2 * time.Second)
+	for {
+		sender.WriteToUDP(ts, group)
+		select {
+		case cue := <-found:
+			if cue.Encode2B64() != testCue {
+				t.Fatalf("got %v", cue.Encode2B64())
+			}
+			cancel()
+			if err := <-errc; err != context.Canceled {
+				t.Fatalf("got %v, want %v", err, context.Canceled)
+			}
+			return
+		case err := <-errc:
+			t.Skipf("can't join %v: %v", source, err)
+		case <-deadline:
+			t.Skip("no multicast loopback")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
+
+// freePort returns a UDP port that isn't in use.
+func freePort(t *testing.T) int {
+	t.Helper()
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer probe.Close()
+	return probe.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestMulticastLoopback(t *testing.T) {
+	iface, addr := mcastIface(t)
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 35, 35), Port: freePort(t)}
+	source := fmt.Sprintf("udp://@%v?iface=%v&pkt=1316&rcvbuf=262144", group, iface.Name)
+	mcastLoopback(t, source, group, addr)
+}
+
+func TestSsmLoopback(t *testing.T) {
+	iface, addr := mcastIface(t)
+	group := &net.UDPAddr{IP: net.IPv4(232, 35, 35, 35), Port: freePort(t)}
+	source := fmt.Sprintf("udp://%v@%v?iface=%v&pkt=1316", addr, group, iface.Name)
+	mcastLoopback(t, source, group, addr)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package cuei
+
+import (
+	"fmt"
+	"net"
+)
+
+// listenSsm is only supported on Linux.
+func listenSsm(opts *mcastOpts) (*net.UDPConn, error) {
+	return nil, fmt.Errorf("source specific multicast is only supported on Linux")
+}
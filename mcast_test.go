@@ -0,0 +1,45 @@
+package cuei
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseMcast(t *testing.T) {
+	ifaces, _ := net.Interfaces()
+	iface := ""
+	if len(ifaces) > 0 {
+		iface = ifaces[0].Name
+	}
+	opts, err := parseMcast("udp://10.0.0.7@232.1.1.1:5000?iface=" + iface + "&pkt=1316&rcvbuf=8388608")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.group.String() != "232.1.1.1:5000" || !opts.source.Equal(net.IPv4(10, 0, 0, 7)) {
+		t.Fatalf("group %v source %v", opts.group, opts.source)
+	}
+	if opts.dgram != 1316 || opts.rcvbuf != 8388608 || opts.fec || opts.depth != 0 {
+		t.Fatalf("pkt %v rcvbuf %v fec %v depth %v", opts.dgram, opts.rcvbuf, opts.fec, opts.depth)
+	}
+	if iface != "" && (opts.iface == nil || opts.iface.Name != iface) {
+		t.Fatalf("iface is %v, want %v", opts.iface, iface)
+	}
+	opts, err = parseMcast("udp://@235.35.3.5:3535?fec=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.source != nil || opts.dgram != maxDgram || opts.rcvbuf != rcvBuf || !opts.fec || opts.depth != fecDepth {
+		t.Fatalf("defaults are wrong: %+v", opts)
+	}
+	for _, bad := range []string{
+		"udp://@10.0.0.1:3535",
+		"udp://@235.35.3.5:3535?pkt=0",
+		"udp://@235.35.3.5:3535?rcvbuf=big",
+		"udp://@235.35.3.5:3535?iface=no-such-iface",
+		"udp://nowhere@235.35.3.5:3535",
+	} {
+		if _, err := parseMcast(bad); err == nil {
+			t.Errorf("no error for %v", bad)
+		}
+	}
+}
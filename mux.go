@@ -0,0 +1,449 @@
+package cuei
+
+import (
+	"io"
+	"sync"
+)
+
+// scte35Type is the PMT stream type for SCTE-35.
+const scte35Type = 0x86
+
+// cueiReg is a registration descriptor with format_identifier CUEI.
+var cueiReg = []byte{0x05, 0x04, 'C', 'U', 'E', 'I'}
+
+/*
+Muxer inserts Cues into MPEGTS.
+
+MPEGTS written to a Muxer is passed through to the io.Writer
+given to NewMuxer. The PMT of the program is rewritten to add
+a SCTE-35 stream, type 0x86 with a CUEI registration descriptor,
+unless it already has one, and its version_number is incremented.
+Without Muxer.Pid, the SCTE-35 PID is the first PID after the
+program's highest that isn't reserved or used in the MPEGTS,
+picked once the PMT of every program in the PAT has been seen.
+
+Cues passed to Muxer.Insert are written on the SCTE-35 PID
+when the program PCR reaches Muxer.Preroll seconds before the splice time.
+Splice immediate Cues and Time Signals without a time are written right away.
+188, 192 and 204 byte packets are found like Stream.DecodeBytes does.
+*/
+type Muxer struct {
+	Program uint16  // Program to add SCTE-35 to, zero for the first program in the PAT.
+	Pid     uint16  // Pid for SCTE-35 if the PMT doesn't have one, zero for an unused PID.
+	Preroll float64 // Preroll is how many seconds ahead of the splice time Cues are written.
+	stream  *Stream
+	pw      *pktWriter
+	pmts    partials // pmts are PMT sections spread across packets
+	pid     uint16   // pid is the SCTE-35 PID, once the PMT has been seen
+	pmtSeen int      // pmtSeen counts PMT sections for the program before pid is picked
+	ccs     counters // ccs are continuity counters for rewritten PIDs
+	mu      sync.Mutex
+	queue   []*Cue
+}
+
+// NewMuxer initializes and returns a *Muxer writing to wr, with 4 seconds of preroll.
+func NewMuxer(wr io.Writer) *Muxer {
+	mux := &Muxer{Preroll: 4.0}
+	mux.stream = NewStream()
+	mux.stream.Quiet = true
+	mux.stream.Handle(func(*Cue) {})
+	mux.pw = newPktWriter(mux.stream, wr, mux.mux)
+	mux.pmts = make(partials)
+	mux.ccs = make(counters)
+	return mux
+}
+
+// Insert queues cue to be written ahead of its splice time, Insert is safe to call while writing.
+func (mux *Muxer) Insert(cue *Cue) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	mux.queue = append(mux.queue, cue)
+}
+
+/*
+Write muxes the MPEGTS in p, so a Muxer can be used with io.Copy.
+p doesn't need to end on a packet boundary.
+*/
+func (mux *Muxer) Write(p []byte) (int, error) {
+	return mux.pw.Write(p)
+}
+
+/*
+Flush writes any partial packet left from Write,
+then any queued Cues once the SCTE-35 PID is known.
+*/
+func (mux *Muxer) Flush() error {
+	err := mux.pw.Flush()
+	if mux.pid == 0 {
+		return err
+	}
+	var out []byte
+	mux.mu.Lock()
+	for _, cue := range mux.queue {
+		out = append(out, mux.ccs.packetize(cue.Encode(), mux.pid)...)
+	}
+	mux.queue = nil
+	mux.mu.Unlock()
+	if err2 := mux.pw.writePkts(out); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// mux parses pkt and returns it, rewritten if needed, then any Cues that are due.
+func (mux *Muxer) mux(pkt []byte) []byte {
+	mux.stream.parse(pkt)
+	pid := parsePid(pkt[1], pkt[2])
+	out := pkt
+	switch {
+	case mux.stream.Pids.isPmtPid(pid):
+		mux.ccs.seed(pkt, pid)
+		out = mux.rewritePmt(pkt, pid)
+	case pid == mux.pid:
+		mux.ccs.seed(pkt, pid)
+		out = mux.ccs.restamp(pkt, pid)
+	}
+	afc := mux.stream.afcFlag(pkt) && pkt[4] > 0
+	if afc && mux.stream.pcrFlag(pkt) && mux.stream.Pids.isPcrPid(pid) {
+		out = append(append([]byte(nil), out...), mux.due()...)
+	}
+	return out
+}
+
+// program returns the program SCTE-35 is added to.
+func (mux *Muxer) program() uint16 {
+	if mux.Program != 0 || len(mux.stream.Programs) == 0 {
+		return mux.Program
+	}
+	return mux.stream.Programs[0]
+}
+
+// due returns packets for queued Cues that are due at the program PCR.
+func (mux *Muxer) due() []byte {
+	prgm := mux.program()
+	pcr, ok := mux.stream.Prgm2Pcr[prgm]
+	if mux.pid == 0 || !ok || mux.stream.Pid2Prgm[mux.pid] != prgm {
+		return nil
+	}
+	now := Pts(pcr)
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	var out []byte
+	var queue []*Cue
+	for _, cue := range mux.queue {
+		cmd := cue.Command
+		if cmd != nil && cmd.TimeSpecifiedFlag && !cmd.SpliceImmediateFlag {
+			if now.Before(NewPts(cue.splicePts()).Add(-mux.Preroll)) {
+				queue = append(queue, cue)
+				continue
+			}
+		}
+		out = append(out, mux.ccs.packetize(cue.Encode(), mux.pid)...)
+	}
+	mux.queue = queue
+	return out
+}
+
+/*
+rewritePmt collects PMT sections on pid and returns them
+as packets, once they're complete, with SCTE-35 added.
+*/
+func (mux *Muxer) rewritePmt(pkt []byte, pid uint16) []byte {
+	pay := mux.stream.parsePayload(pkt)
+	var out []byte
+	for _, sec := range mux.pmts.sections(pay, pid, mux.stream.parsePusi(pkt)) {
+		out = append(out, mux.ccs.packetize(mux.addScte35(sec), pid)...)
+	}
+	return out
+}
+
+/*
+addScte35 adds a SCTE-35 stream to a PMT section for the program,
+or sets Muxer.pid from the one it has.
+*/
+func (mux *Muxer) addScte35(sec []byte) []byte {
+	if sec[0] != 0x02 || len(sec) < 16 || !crcOk(sec) || parsePrgm(sec[3], sec[4]) != mux.program() {
+		return sec
+	}
+	end := len(sec) - 4
+	idx := 12 + int(parseLen(sec[10], sec[11]))
+	if idx > end {
+		return sec
+	}
+	highest := uint16(0)
+	for idx+5 <= end {
+		elpid := parsePid(sec[idx+1], sec[idx+2])
+		next := idx + 5 + int(parseLen(sec[idx+3], sec[idx+4]))
+		if next > end {
+			next = end
+		}
+		if isScte35(sec[idx], parsePmtDescriptors(sec[idx+5:next])) {
+			mux.pid = elpid
+			return sec
+		}
+		if elpid > highest {
+			highest = elpid
+		}
+		idx = next
+	}
+	if mux.pid == 0 {
+		mux.pid = mux.Pid
+		if mux.pid == 0 {
+			mux.pid = mux.freePid(highest)
+		}
+		if mux.pid == 0 {
+			return sec
+		}
+		mux.stream.Pid2Prgm[mux.pid] = mux.program()
+	}
+	body := append([]byte(nil), sec[:end]...)
+	bumpVersion(body)
+	body = append(body, scte35Type, 0xe0|byte(mux.pid>>8), byte(mux.pid), 0xf0, byte(len(cueiReg)))
+	body = append(body, cueiReg...)
+	return finishSection(body)
+}
+
+/*
+freePid returns the first PID after highest that isn't reserved
+or used in the MPEGTS, zero until the PMT for every program in the PAT
+has been seen, or the program's PMT has come round again.
+*/
+func (mux *Muxer) freePid(highest uint16) uint16 {
+	mux.pmtSeen++
+	if mux.pmtSeen < 2 {
+		for _, pmtpid := range mux.stream.Pids.PmtPids {
+			if _, ok := mux.stream.last[pmtpid]; !ok {
+				return 0
+			}
+		}
+	}
+	pid := highest
+	for i := minPid; i <= maxPid; i++ {
+		pid++
+		if pid < minPid || pid > maxPid {
+			pid = minPid
+		}
+		if !mux.pidUsed(pid) {
+			return pid
+		}
+	}
+	return 0
+}
+
+// PIDs below minPid, like the PAT, NIT and SDT, and above maxPid, like the VCT and null packets, are reserved.
+const (
+	minPid = 0x20
+	maxPid = 0x1ffa
+)
+
+// pidUsed returns true if pid is in the PAT or a PMT, or has been seen in the MPEGTS.
+func (mux *Muxer) pidUsed(pid uint16) bool {
+	stream := mux.stream
+	_, inPmt := stream.Pid2Prgm[pid]
+	_, seen := stream.Pid2Stats[pid]
+	return inPmt || seen || stream.Pids.isPmtPid(pid) || stream.Pids.isPcrPid(pid)
+}
+
+// bumpVersion increments the version_number of a PSI section.
+func bumpVersion(body []byte) {
+	version := (body[5]>>1 + 1) & 0x1f
+	body[5] = body[5]&0xc1 | version<<1
+}
+
+/*
+finishSection sets the section_length of a PSI section
+without its CRC_32, then appends the CRC_32.
+*/
+func finishSection(body []byte) []byte {
+	seclen := len(body) + 4 - 3
+	body[1] = body[1]&0xf0 | byte(seclen>>8)
+	body[2] = byte(seclen)
+	return appendCrc32(body)
+}
+
+/*
+pktWriter finds packets in MPEGTS written to it, 188, 192 or 204 bytes,
+like Stream.DecodeBytes, passes each 188 byte packet to fn
+and writes the 188 byte packets fn returns to wr.
+
+Packets written are framed like the packet they replace,
+192 byte packets get its arrival timestamp and 204 byte packets
+its last 16 bytes, the Reed-Solomon parity isn't recomputed.
+Bytes that are out of sync are written as they are.
+*/
+type pktWriter struct {
+	stream *Stream
+	wr     io.Writer
+	fn     func(pkt []byte) []byte
+	head   []byte // head is the bytes before the 188 byte packet in the last packet
+	tail   []byte // tail is the bytes after it
+	err    error  // err is the first write error since the last Write or Flush
+}
+
+// newPktWriter returns a *pktWriter finding packets with stream, passing them to fn, writing to wr.
+func newPktWriter(stream *Stream, wr io.Writer, fn func(pkt []byte) []byte) *pktWriter {
+	return &pktWriter{stream: stream, wr: wr, fn: fn}
+}
+
+// Write passes the packets in p to fn, p doesn't need to end on a packet boundary.
+func (pw *pktWriter) Write(p []byte) (int, error) {
+	pw.stream.split(p, pw.packet, pw.write)
+	return len(p), pw.takeErr()
+}
+
+// Flush passes any partial packet left from Write to fn, or writes it.
+func (pw *pktWriter) Flush() error {
+	pw.stream.splitCarry(pw.packet, pw.write)
+	return pw.takeErr()
+}
+
+// writePkts writes 188 byte packets framed like the last packet.
+func (pw *pktWriter) writePkts(pkts []byte) error {
+	pw.frame(pkts)
+	return pw.takeErr()
+}
+
+// packet passes pkt to fn and writes what it returns, framed like unit.
+func (pw *pktWriter) packet(unit []byte, pkt []byte) {
+	off := syncOffset(pw.stream.unit)
+	pw.head = append(pw.head[:0], unit[:off]...)
+	pw.tail = append(pw.tail[:0], unit[off+pktSz:]...)
+	pw.frame(pw.fn(pkt))
+}
+
+// frame writes 188 byte packets with the head and tail of the last packet.
+func (pw *pktWriter) frame(pkts []byte) {
+	if len(pw.head) == 0 && len(pw.tail) == 0 {
+		pw.write(pkts)
+		return
+	}
+	var out []byte
+	for ; len(pkts) >= pktSz; pkts = pkts[pktSz:] {
+		out = append(out, pw.head...)
+		out = append(out, pkts[:pktSz]...)
+		out = append(out, pw.tail...)
+	}
+	pw.write(out)
+}
+
+// write writes bites to wr, unless a write has failed.
+func (pw *pktWriter) write(bites []byte) {
+	if pw.err == nil && len(bites) > 0 {
+		_, pw.err = pw.wr.Write(bites)
+	}
+}
+
+// takeErr returns and clears the write error.
+func (pw *pktWriter) takeErr() error {
+	err := pw.err
+	pw.err = nil
+	return err
+}
+
+// counters are continuity counters for PIDs being rewritten.
+type counters map[uint16]uint8
+
+// seed starts the continuity counter for pid at the one in pkt.
+func (ccs counters) seed(pkt []byte, pid uint16) {
+	if _, ok := ccs[pid]; !ok {
+		ccs[pid] = pkt[3] & 0x0f
+	}
+}
+
+// restamp returns a copy of pkt with the next continuity counter for pid.
+func (ccs counters) restamp(pkt []byte, pid uint16) []byte {
+	out := append([]byte(nil), pkt...)
+	cc := ccs[pid]
+	if out[3]&0x10 == 0x10 {
+		ccs[pid] = (cc + 1) & 0x0f
+	} else {
+		cc = (cc - 1) & 0x0f // no payload, no increment
+	}
+	out[3] = out[3]&0xf0 | cc
+	return out
+}
+
+// packetize returns sec as packets on pid, using the next continuity counters.
+func (ccs counters) packetize(sec []byte, pid uint16) []byte {
+	pkts, cc := packetize(sec, pid, ccs[pid])
+	ccs[pid] = cc
+	return pkts
+}
+
+// packetizePes returns sec in a PES packet as packets on pid, using the next continuity counters.
+func (ccs counters) packetizePes(sec []byte, pid uint16) []byte {
+	pkts, cc := packetizePes(sec, pid, ccs[pid])
+	ccs[pid] = cc
+	return pkts
+}
+
+/*
+packetize splits a PSI section into packets on pid, starting
+at continuity counter cc, with a pointer_field and 0xff stuffing.
+It returns the packets and the next continuity counter.
+*/
+func packetize(sec []byte, pid uint16, cc uint8) ([]byte, uint8) {
+	return packetizeUnit(append([]byte{0}, sec...), pid, cc)
+}
+
+/*
+packetizePes wraps a SCTE-35 section in a PES packet,
+then splits it into packets like packetize, without a pointer_field.
+*/
+func packetizePes(sec []byte, pid uint16, cc uint8) ([]byte, uint8) {
+	pesLen := 3 + len(sec)
+	unit := append([]byte(nil), pesStart...)
+	unit = append(unit, byte(pesLen>>8), byte(pesLen), 0x80, 0x00, 0x00)
+	return packetizeUnit(append(unit, sec...), pid, cc)
+}
+
+// packetizeUnit splits a payload unit into packets, PUSI set on the first.
+func packetizeUnit(unit []byte, pid uint16, cc uint8) ([]byte, uint8) {
+	var pkts []byte
+	pusi := byte(0x40)
+	for len(unit) > 0 {
+		pkt := []byte{syncByte, pusi | byte(pid>>8&0x1f), byte(pid), 0x10 | cc&0x0f}
+		n := pktSz - len(pkt)
+		if n > len(unit) {
+			n = len(unit)
+		}
+		pkt = append(pkt, unit[:n]...)
+		unit = unit[n:]
+		for len(pkt) < pktSz {
+			pkt = append(pkt, 0xff)
+		}
+		pkts = append(pkts, pkt...)
+		pusi = 0
+		cc = (cc + 1) & 0x0f
+	}
+	return pkts, cc
+}
+
+/*
+Encode2Packets encodes the Cue as 188 byte MPEGTS packets on pid,
+the first with continuity counter cc. It returns the packets
+and the next continuity counter, for the next call.
+*/
+func (cue *Cue) Encode2Packets(pid uint16, cc uint8) ([][]byte, uint8) {
+	pkts, next := packetize(cue.Encode(), pid, cc)
+	return splitPackets(pkts), next
+}
+
+/*
+Encode2PesPackets is Encode2Packets with the section
+in a PES packet, stream_id 0xfc, as some muxers expect.
+*/
+func (cue *Cue) Encode2PesPackets(pid uint16, cc uint8) ([][]byte, uint8) {
+	pkts, next := packetizePes(cue.Encode(), pid, cc)
+	return splitPackets(pkts), next
+}
+
+// splitPackets splits bites into 188 byte packets.
+func splitPackets(bites []byte) [][]byte {
+	var pkts [][]byte
+	for len(bites) >= pktSz {
+		pkts = append(pkts, bites[:pktSz])
+		bites = bites[pktSz:]
+	}
+	return pkts
+}
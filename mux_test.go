@@ -0,0 +1,283 @@
+package cuei
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// pmtSec returns a PMT section for prgm with version, the PCR on the first PID, streams are type and PID pairs.
+func pmtSec(prgm uint16, version uint8, streams ...uint16) []byte {
+	sec := []byte{0x02, 0xb0, 0, byte(prgm >> 8), byte(prgm), 0xc1 | version<<1, 0, 0,
+		0xe0 | byte(streams[1]>>8), byte(streams[1]), 0xf0, 0x00}
+	for i := 0; i+1 < len(streams); i += 2 {
+		sec = append(sec, byte(streams[i]), 0xe0|byte(streams[i+1]>>8), byte(streams[i+1]), 0xf0, 0x00)
+	}
+	return finishSection(sec)
+}
+
+// psiVersions returns the version_number of each PSI section on pid in ts.
+func psiVersions(ts []byte, pid uint16) []uint8 {
+	stream := NewStream()
+	parts := make(partials)
+	var versions []uint8
+	for i := 0; i+pktSz <= len(ts); i += pktSz {
+		pkt := ts[i : i+pktSz]
+		if parsePid(pkt[1], pkt[2]) != pid {
+			continue
+		}
+		for _, sec := range parts.sections(stream.parsePayload(pkt), pid, stream.parsePusi(pkt)) {
+			versions = append(versions, sec[5]>>1&0x1f)
+		}
+	}
+	return versions
+}
+
+func TestMuxer(t *testing.T) {
+	pat := finishSection([]byte{0x00, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0, 0x00, 0x01, 0xe0, 0x30, 0x00, 0x02, 0xe0, 0x31})
+	// program 2 has the next PID up, 0x102 is in the MPEGTS, not in a PMT
+	pmt1 := pmtSec(1, 3, 0x1b, 0x100)
+	pmt2 := pmtSec(2, 0, 0x1b, 0x101)
+	var in []byte
+	for i := uint8(0); i < 2; i++ {
+		pkts, _ := packetize(pat, 0, i)
+		in = append(in, pkts...)
+		pkts, _ = packetize(pmt1, 0x30, i)
+		in = append(in, pkts...)
+		pkts, _ = packetize(pmt2, 0x31, i)
+		in = append(in, pkts...)
+		in = append(in, ccPkt(0x102, i, "p")...)
+	}
+	// past the splice time of testCue
+	in = append(in, pcrPkt(0x100, 0, 200*90000)...)
+	var out bytes.Buffer
+	mux := NewMuxer(&out)
+	cue := NewCue()
+	cue.Decode(testCue)
+	mux.Insert(cue)
+	// odd sized writes
+	for i := 0; i < len(in); i += 100 {
+		end := i + 100
+		if end > len(in) {
+			end = len(in)
+		}
+		mux.Write(in[i:end])
+	}
+	if err := mux.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if mux.pid != 0x103 {
+		t.Fatalf("SCTE-35 PID is %#x, want 0x103", mux.pid)
+	}
+	// the first PMT is passed through, until the PMT for program 2 is seen
+	if versions := psiVersions(out.Bytes(), 0x30); !bytes.Equal(versions, []uint8{3, 4}) {
+		t.Fatalf("PMT versions are %v, want [3 4]", versions)
+	}
+	stream := NewStream()
+	stream.Quiet = true
+	cues := stream.DecodeBytes(out.Bytes())
+	if len(cues) != 1 {
+		t.Fatalf("got %d Cues, want 1", len(cues))
+	}
+	pd := cues[0].PacketData
+	if pd.Pid != 0x103 || pd.Program != 1 || stream.Pid2Type[0x103] != scte35Type {
+		t.Fatalf("PacketData is %v, stream type %#x", mkJson(pd), stream.Pid2Type[0x103])
+	}
+	if cues[0].Encode2B64() != testCue {
+		t.Fatalf("Cue is %v, want %v", cues[0].Encode2B64(), testCue)
+	}
+}
+
+func TestMuxerScte35Pid(t *testing.T) {
+	ts := testTs()
+	ts = append(ts, pcrPkt(testVidPid, 0, 200*90000)...)
+	var out bytes.Buffer
+	mux := NewMuxer(&out)
+	cue := NewCue()
+	cue.Decode(testCue)
+	mux.Insert(cue)
+	mux.Write(ts)
+	mux.Flush()
+	if mux.pid != testScte35Pid {
+		t.Fatalf("SCTE-35 PID is %#x, want %#x", mux.pid, testScte35Pid)
+	}
+	// the PMT already has SCTE-35, it's not rewritten
+	if !bytes.Equal(out.Bytes()[:len(ts)], ts) {
+		t.Fatal("MPEGTS was changed")
+	}
+	stream := NewStream()
+	stream.Quiet = true
+	if cues := stream.DecodeBytes(out.Bytes()); len(cues) != 1 || cues[0].PacketData.Pid != testScte35Pid {
+		t.Fatalf("got %d Cues", len(cues))
+	}
+}
+
+func TestMuxerPreroll(t *testing.T) {
+	tests := []struct {
+		name   string
+		splice uint64   // splice time in ticks
+		held   []uint64 // PCRs before the Cue is due
+		due    uint64   // PCR the Cue is written after
+	}{
+		// testCue splices at 123.456789, 4 seconds of preroll
+		{"testCue", 11111111, []uint64{0, 119 * 90000}, 120 * 90000},
+		{"splice before the wrap", PtsWrap - 2*90000, []uint64{PtsWrap - 7*90000}, PtsWrap - 5*90000},
+		{"due before the wrap, splice after it", 2 * 90000, []uint64{PtsWrap - 3*90000}, PtsWrap - 90000},
+		{"PCR wraps before the Cue is due", 10 * 90000, []uint64{PtsWrap - 90000, 90000}, 7 * 90000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			mux := NewMuxer(&out)
+			cue := NewCue()
+			cue.Decode(testCue)
+			cue.Command.PTS = float64(tt.splice) / 90000.0
+			mux.Insert(cue)
+			mux.Write(testTs())
+			for i, pcr := range tt.held {
+				mux.Write(pcrPkt(testVidPid, uint8(i), pcr))
+				if len(mux.queue) != 1 || len(out.Bytes()) != (3+i)*pktSz {
+					t.Fatalf("Cue written at PCR %d", pcr)
+				}
+			}
+			mux.Write(pcrPkt(testVidPid, uint8(len(tt.held)), tt.due))
+			if len(mux.queue) != 0 {
+				t.Fatalf("Cue not written at PCR %d", tt.due)
+			}
+			stream := NewStream()
+			stream.Quiet = true
+			cues := stream.DecodeBytes(out.Bytes())
+			if len(cues) != 1 || NewPts(cues[0].Command.PTS) != Pts(tt.splice) {
+				t.Fatalf("got %d Cues", len(cues))
+			}
+			// right after the PCR packet
+			if want := (3 + len(tt.held)) * pktSz; len(out.Bytes()) != want+pktSz {
+				t.Fatalf("wrote %d bytes, want %d", len(out.Bytes()), want+pktSz)
+			}
+		})
+	}
+}
+
+// writeChunks writes ts to wr chunk bytes at a time.
+func writeChunks(t *testing.T, wr io.Writer, ts []byte, chunk int) {
+	t.Helper()
+	for len(ts) > 0 {
+		n := chunk
+		if n > len(ts) {
+			n = len(ts)
+		}
+		if _, err := wr.Write(ts[:n]); err != nil {
+			t.Fatal(err)
+		}
+		ts = ts[n:]
+	}
+}
+
+func TestPktWriter(t *testing.T) {
+	// sync bytes, but never a packet size apart
+	garbage := bytes.Repeat([]byte{0x47, 0xff, 0, 1, 2, 3, 4}, 48)
+	ts := testTs(testCues(3)...)
+	for _, sz := range pktSizes {
+		// garbage between the cues and a partial packet at the end
+		in := append(resize(ts[:3*pktSz], sz), garbage...)
+		in = append(in, resize(ts[3*pktSz:], sz)...)
+		in = append(in, 0x47, 0x01, 0x02)
+		var out bytes.Buffer
+		flt := NewFilter(&out)
+		flt.Keep = func(*Cue) bool { return true }
+		writeChunks(t, flt, in, 1000)
+		if err := flt.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out.Bytes(), in) {
+			t.Fatalf("%d byte packets were changed", sz)
+		}
+		// the Muxer writes new packets framed like the others
+		out.Reset()
+		mux := NewMuxer(&out)
+		cue := NewCue()
+		cue.Decode(testCue)
+		mux.Insert(cue)
+		writeChunks(t, mux, resize(append(testTs(), pcrPkt(testVidPid, 0, 200*90000)...), sz), 1000)
+		mux.Flush()
+		stream := NewStream()
+		stream.Quiet = true
+		stream.PacketSize = sz
+		if cues := stream.DecodeBytes(out.Bytes()); len(cues) != 1 || out.Len()%sz != 0 {
+			t.Fatalf("%d byte packets: got %d Cues from %d bytes", sz, len(cues), out.Len())
+		}
+	}
+}
+
+// wantPkt returns a 188 byte packet on pid with cc, PUSI set when pusi, the payload pay and 0xff stuffing.
+func wantPkt(pusi bool, pid uint16, cc uint8, pay ...[]byte) []byte {
+	pkt := []byte{syncByte, byte(pid >> 8), byte(pid), 0x10 | cc}
+	if pusi {
+		pkt[1] |= 0x40
+	}
+	pkt = append(pkt, cat(pay...)...)
+	return append(pkt, bytes.Repeat([]byte{0xff}, pktSz-len(pkt))...)
+}
+
+func TestEncode2Packets(t *testing.T) {
+	small := NewCue()
+	small.Decode(testCue)
+	sec := small.Encode()
+	big := NewCue()
+	big.Decode(segCue(0x09, bytes.Repeat([]byte{'A'}, 200)))
+	bigSec := big.Encode()
+	pesLen := 3 + len(sec)
+	pesHead := []byte{0, 0, 1, 0xfc, byte(pesLen >> 8), byte(pesLen), 0x80, 0, 0}
+	tests := []struct {
+		name   string
+		encode func(pid uint16, cc uint8) ([][]byte, uint8)
+		cc     uint8
+		want   [][]byte
+		next   uint8
+	}{
+		{"one packet", small.Encode2Packets, 3, [][]byte{wantPkt(true, 0x86, 3, []byte{0}, sec)}, 4},
+		{"two packets across the cc wrap", big.Encode2Packets, 15,
+			[][]byte{wantPkt(true, 0x86, 15, []byte{0}, bigSec[:183]), wantPkt(false, 0x86, 0, bigSec[183:])}, 1},
+		{"pes", small.Encode2PesPackets, 7, [][]byte{wantPkt(true, 0x86, 7, pesHead, sec)}, 8},
+		{"pes, two packets", big.Encode2PesPackets, 0,
+			[][]byte{wantPkt(true, 0x86, 0, []byte{0, 0, 1, 0xfc, 0, byte(3 + len(bigSec)), 0x80, 0, 0}, bigSec[:175]),
+				wantPkt(false, 0x86, 1, bigSec[175:])}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkts, next := tt.encode(0x86, tt.cc)
+			if len(pkts) != len(tt.want) || next != tt.next {
+				t.Fatalf("got %d packets, next cc %d, want %d, %d", len(pkts), next, len(tt.want), tt.next)
+			}
+			for i := range pkts {
+				if !bytes.Equal(pkts[i], tt.want[i]) {
+					t.Fatalf("packet %d\n got %x\nwant %x", i, pkts[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEncode2PacketsChained(t *testing.T) {
+	cue := NewCue()
+	cue.Decode(segCue(0x09, bytes.Repeat([]byte{'A'}, 200)))
+	ts := testTs()
+	cc := uint8(14)
+	for i := 0; i < 3; i++ {
+		var pkts [][]byte
+		if i == 1 {
+			pkts, cc = cue.Encode2PesPackets(testScte35Pid, cc)
+		} else {
+			pkts, cc = cue.Encode2Packets(testScte35Pid, cc)
+		}
+		ts = append(ts, cat(pkts...)...)
+	}
+	stream := NewStream()
+	stream.Quiet = true
+	if cues := stream.DecodeBytes(ts); len(cues) != 3 {
+		t.Fatalf("got %d Cues, want 3", len(cues))
+	}
+	if stats := stream.Pid2Stats[testScte35Pid]; stats.CcErrors != 0 || stats.Packets != 6 {
+		t.Fatalf("stats are %+v", stats)
+	}
+}
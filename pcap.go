@@ -0,0 +1,371 @@
+package cuei
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+)
+
+// pcap and pcapng link types
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSll      = 113
+	linkSll2     = 276
+)
+
+// pcapng block types
+const (
+	blockShb = 0x0a0d0d0a
+	blockIdb = 0x00000001
+	blockSpb = 0x00000003
+	blockEpb = 0x00000006
+)
+
+// maxCaplen is the largest captured frame read, the libpcap maximum snaplen.
+const maxCaplen = 262144
+
+// maxBlock is the largest pcapng block read, a frame plus room for headers and options.
+const maxBlock = maxCaplen + 4096
+
+// pcapIface is a pcapng interface.
+type pcapIface struct {
+	link    uint16
+	tsres   float64 // seconds per timestamp tick
+	snaplen int     // zero for no limit
+}
+
+// checkCaplen returns an error if caplen is more than the snaplen of iface or maxCaplen.
+func (iface pcapIface) checkCaplen(caplen int) error {
+	if caplen > maxCaplen || (iface.snaplen > 0 && caplen > iface.snaplen) {
+		return fmt.Errorf("bad capture length %v, snaplen is %v", caplen, iface.snaplen)
+	}
+	return nil
+}
+
+/*
+pcapReader reads packets from pcap and pcapng captures.
+Only the pieces needed to get UDP payloads are parsed.
+*/
+type pcapReader struct {
+	rdr    *bufio.Reader
+	order  binary.ByteOrder
+	ng     bool
+	ifaces []pcapIface
+}
+
+// newPcapReader reads the capture header and returns a *pcapReader.
+func newPcapReader(rdr io.Reader) (*pcapReader, error) {
+	pr := &pcapReader{rdr: bufio.NewReader(rdr)}
+	magic, err := pr.rdr.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(magic) == blockShb {
+		pr.ng = true
+		return pr, nil
+	}
+	head := make([]byte, 24)
+	if _, err = io.ReadFull(pr.rdr, head); err != nil {
+		return nil, err
+	}
+	tsres := 1e-6
+	switch {
+	case binary.LittleEndian.Uint32(head) == 0xa1b2c3d4:
+		pr.order = binary.LittleEndian
+	case binary.BigEndian.Uint32(head) == 0xa1b2c3d4:
+		pr.order = binary.BigEndian
+	case binary.LittleEndian.Uint32(head) == 0xa1b23c4d:
+		pr.order = binary.LittleEndian
+		tsres = 1e-9
+	case binary.BigEndian.Uint32(head) == 0xa1b23c4d:
+		pr.order = binary.BigEndian
+		tsres = 1e-9
+	default:
+		return nil, fmt.Errorf("not a pcap or pcapng capture")
+	}
+	snaplen := int(pr.order.Uint32(head[16:20]))
+	link := uint16(pr.order.Uint32(head[20:24]))
+	pr.ifaces = []pcapIface{{link: link, tsres: tsres, snaplen: snaplen}}
+	return pr, nil
+}
+
+// next returns the next captured frame, its capture time in seconds and link type.
+func (pr *pcapReader) next() ([]byte, float64, uint16, error) {
+	if pr.ng {
+		return pr.nextBlock()
+	}
+	rec := make([]byte, 16)
+	if _, err := io.ReadFull(pr.rdr, rec); err != nil {
+		return nil, 0, 0, err
+	}
+	iface := pr.ifaces[0]
+	secs := float64(pr.order.Uint32(rec[0:4]))
+	frac := float64(pr.order.Uint32(rec[4:8])) * iface.tsres
+	caplen := int(pr.order.Uint32(rec[8:12]))
+	if err := iface.checkCaplen(caplen); err != nil {
+		return nil, 0, 0, err
+	}
+	frame := make([]byte, caplen)
+	if _, err := io.ReadFull(pr.rdr, frame); err != nil {
+		return nil, 0, 0, err
+	}
+	return frame, secs + frac, iface.link, nil
+}
+
+// nextBlock reads pcapng blocks until a packet block.
+func (pr *pcapReader) nextBlock() ([]byte, float64, uint16, error) {
+	for {
+		head := make([]byte, 8)
+		if _, err := io.ReadFull(pr.rdr, head); err != nil {
+			return nil, 0, 0, err
+		}
+		if binary.BigEndian.Uint32(head) == blockShb {
+			bom := make([]byte, 4)
+			if _, err := io.ReadFull(pr.rdr, bom); err != nil {
+				return nil, 0, 0, err
+			}
+			pr.order = binary.BigEndian
+			if binary.LittleEndian.Uint32(bom) == 0x1a2b3c4d {
+				pr.order = binary.LittleEndian
+			}
+			pr.ifaces = nil
+			head = append(head, bom...)
+		}
+		btype := pr.order.Uint32(head[0:4])
+		blen := int(pr.order.Uint32(head[4:8]))
+		if blen < 12 || blen%4 != 0 || blen > maxBlock {
+			return nil, 0, 0, fmt.Errorf("bad pcapng block length %v", blen)
+		}
+		body := make([]byte, blen-len(head))
+		if _, err := io.ReadFull(pr.rdr, body); err != nil {
+			return nil, 0, 0, err
+		}
+		body = body[:len(body)-4] // trailing block length
+		switch btype {
+		case blockIdb:
+			pr.addIface(body)
+		case blockEpb:
+			if len(body) < 20 {
+				continue
+			}
+			id := int(pr.order.Uint32(body[0:4]))
+			if id >= len(pr.ifaces) {
+				continue
+			}
+			iface := pr.ifaces[id]
+			ticks := uint64(pr.order.Uint32(body[4:8]))<<32 | uint64(pr.order.Uint32(body[8:12]))
+			caplen := int(pr.order.Uint32(body[12:16]))
+			if err := iface.checkCaplen(caplen); err != nil {
+				return nil, 0, 0, err
+			}
+			if 20+caplen > len(body) {
+				continue
+			}
+			return body[20 : 20+caplen], float64(ticks) * iface.tsres, iface.link, nil
+		case blockSpb:
+			if len(pr.ifaces) == 0 || len(body) < 4 {
+				continue
+			}
+			return body[4:], 0, pr.ifaces[0].link, nil
+		}
+	}
+}
+
+// addIface adds a pcapng interface description block.
+func (pr *pcapReader) addIface(body []byte) {
+	if len(body) < 8 {
+		return
+	}
+	iface := pcapIface{link: pr.order.Uint16(body[0:2]), tsres: 1e-6, snaplen: int(pr.order.Uint32(body[4:8]))}
+	opts := body[8:]
+	for len(opts) >= 4 {
+		code := pr.order.Uint16(opts[0:2])
+		olen := int(pr.order.Uint16(opts[2:4]))
+		if code == 0 || 4+olen > len(opts) {
+			break
+		}
+		if code == 9 && olen >= 1 { // if_tsresol
+			res := opts[4]
+			if res&0x80 == 0x80 {
+				iface.tsres = 1 / float64(uint64(1)<<(res&0x7f))
+			} else {
+				iface.tsres = 1
+				for i := uint8(0); i < res; i++ {
+					iface.tsres /= 10
+				}
+			}
+		}
+		opts = opts[4+(olen+3)/4*4:]
+	}
+	pr.ifaces = append(pr.ifaces, iface)
+}
+
+/*
+udpPayload returns the destination address and UDP payload of a frame.
+VLAN tags are skipped, IP fragments after the first are not.
+*/
+func udpPayload(link uint16, frame []byte) (*net.UDPAddr, []byte, bool) {
+	var ether uint16
+	switch link {
+	case linkEthernet:
+		if len(frame) < 14 {
+			return nil, nil, false
+		}
+		ether = binary.BigEndian.Uint16(frame[12:14])
+		frame = frame[14:]
+		for (ether == 0x8100 || ether == 0x88a8) && len(frame) >= 4 {
+			ether = binary.BigEndian.Uint16(frame[2:4])
+			frame = frame[4:]
+		}
+	case linkNull, linkLoop:
+		if len(frame) < 4 {
+			return nil, nil, false
+		}
+		frame = frame[4:]
+	case linkSll:
+		if len(frame) < 16 {
+			return nil, nil, false
+		}
+		frame = frame[16:]
+	case linkSll2:
+		if len(frame) < 20 {
+			return nil, nil, false
+		}
+		frame = frame[20:]
+	case linkRaw:
+	default:
+		return nil, nil, false
+	}
+	if len(frame) < 1 {
+		return nil, nil, false
+	}
+	if ether == 0 {
+		ether = 0x0800
+		if frame[0]>>4 == 6 {
+			ether = 0x86dd
+		}
+	}
+	var dst net.IP
+	switch ether {
+	case 0x0800:
+		if len(frame) < 20 || frame[0]>>4 != 4 || frame[9] != 17 {
+			return nil, nil, false
+		}
+		if binary.BigEndian.Uint16(frame[6:8])&0x1fff != 0 {
+			return nil, nil, false // not the first fragment
+		}
+		ihl := int(frame[0]&0x0f) * 4
+		if len(frame) < ihl {
+			return nil, nil, false
+		}
+		dst = net.IP(frame[16:20])
+		frame = frame[ihl:]
+	case 0x86dd:
+		if len(frame) < 40 || frame[6] != 17 {
+			return nil, nil, false
+		}
+		dst = net.IP(frame[24:40])
+		frame = frame[40:]
+	default:
+		return nil, nil, false
+	}
+	if len(frame) < 8 {
+		return nil, nil, false
+	}
+	port := int(binary.BigEndian.Uint16(frame[2:4]))
+	end := int(binary.BigEndian.Uint16(frame[4:6]))
+	if end < 8 || end > len(frame) {
+		end = len(frame)
+	}
+	return &net.UDPAddr{IP: dst, Port: port}, frame[8:end], true
+}
+
+// pcapFilter matches UDP destinations to dst, "group:port", ":port", "group" or "" for all.
+func pcapFilter(dst string) (func(*net.UDPAddr) bool, error) {
+	if dst == "" {
+		return func(*net.UDPAddr) bool { return true }, nil
+	}
+	host, port, err := net.SplitHostPort(dst)
+	if err != nil {
+		host, port = dst, ""
+	}
+	var ip net.IP
+	if host != "" {
+		ip = net.ParseIP(host)
+		if ip == nil {
+			return nil, fmt.Errorf("bad address %q", dst)
+		}
+	}
+	pnum := 0
+	if port != "" {
+		pnum, err = strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("bad port %q", dst)
+		}
+	}
+	return func(addr *net.UDPAddr) bool {
+		return (ip == nil || ip.Equal(addr.IP)) && (pnum == 0 || pnum == addr.Port)
+	}, nil
+}
+
+// DecodePcap decodes SCTE-35 from UDP datagrams in a pcap or pcapng file.
+func (stream *Stream) DecodePcap(fname string, dst string) []*Cue {
+	file, err := os.Open(fname)
+	if err != nil {
+		chk(err)
+		return nil
+	}
+	defer file.Close()
+	cues, err := stream.DecodePcapReader(file, dst)
+	chk(err)
+	return cues
+}
+
+/*
+DecodePcapReader decodes SCTE-35 from UDP datagrams in a pcap or pcapng capture.
+
+dst selects datagrams by destination, like "235.35.3.5:3535",
+":3535", "235.35.3.5", or "" for all. Pick one flow, MPEGTS from
+different flows is not kept apart.
+
+RTP is detected and removed, unless Stream.NoRtp is set,
+see Stream.DecodeDatagram.
+The capture time of the datagram that completed each Cue
+is in Cue.PacketData.CaptureTime.
+Ethernet, VLAN, Linux cooked, loopback and raw IP captures are supported.
+*/
+func (stream *Stream) DecodePcapReader(rdr io.Reader, dst string) ([]*Cue, error) {
+	stream.Pids = &Pids{}
+	stream.mkMaps()
+	defer stream.closeChan()
+	match, err := pcapFilter(dst)
+	if err != nil {
+		return nil, err
+	}
+	pr, err := newPcapReader(rdr)
+	if err != nil {
+		return nil, err
+	}
+	var cues []*Cue
+	for {
+		frame, ts, link, err := pr.next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return append(cues, stream.flush()...), nil
+		}
+		if err != nil {
+			return append(cues, stream.flush()...), err
+		}
+		addr, pay, ok := udpPayload(link, frame)
+		if ok && match(addr) {
+			stream.captureTime = ts
+			cues = append(cues, stream.DecodeDatagram(pay)...)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package cuei
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// udpFrame returns an Ethernet frame with an IPv4 UDP datagram to dst:port.
+func udpFrame(dst [4]byte, port uint16, pay []byte) []byte {
+	frame := make([]byte, 14, 42+len(pay))
+	binary.BigEndian.PutUint16(frame[12:14], 0x0800)
+	ip := []byte{0x45, 0, 0, 0, 0, 0, 0x40, 0, 64, 17, 0, 0, 10, 0, 0, 1}
+	binary.BigEndian.PutUint16(ip[2:4], uint16(28+len(pay)))
+	frame = append(append(frame, ip...), dst[:]...)
+	udp := []byte{0x13, 0x88, byte(port >> 8), byte(port), 0, 0, 0, 0}
+	binary.BigEndian.PutUint16(udp[4:6], uint16(8+len(pay)))
+	return append(append(frame, udp...), pay...)
+}
+
+// rtpDgram wraps pay in a RTP header with seq.
+func rtpDgram(seq uint16, pay []byte) []byte {
+	head := []byte{0x80, 33, byte(seq >> 8), byte(seq), 0, 0, 0, 0, 0, 0, 0, 1}
+	return append(head, pay...)
+}
+
+// testPcap returns a little endian microsecond pcap of frames, captured a second apart from 1000.5.
+func testPcap(snaplen uint32, frames ...[]byte) []byte {
+	var buf bytes.Buffer
+	head := []uint32{0xa1b2c3d4, 0x00040002, 0, 0, snaplen, linkEthernet}
+	binary.Write(&buf, binary.LittleEndian, head)
+	for i, frame := range frames {
+		rec := []uint32{uint32(1000 + i), 500000, uint32(len(frame)), uint32(len(frame))}
+		binary.Write(&buf, binary.LittleEndian, rec)
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+// ngBlock returns a little endian pcapng block.
+func ngBlock(btype uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	blen := uint32(12 + len(body))
+	out := binary.LittleEndian.AppendUint32(nil, btype)
+	out = binary.LittleEndian.AppendUint32(out, blen)
+	out = append(out, body...)
+	return binary.LittleEndian.AppendUint32(out, blen)
+}
+
+// testPcapng returns a pcapng with nanosecond timestamps, frames captured a second apart from 1000.5.
+func testPcapng(frames ...[]byte) []byte {
+	shb := []byte{0x4d, 0x3c, 0x2b, 0x1a, 1, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	out := ngBlock(blockShb, shb)
+	// link type, reserved, snaplen, if_tsresol 9, end of options
+	idb := []byte{linkEthernet, 0, 0, 0, 0, 0, 0, 0, 9, 0, 1, 0, 9, 0, 0, 0, 0, 0, 0, 0}
+	out = append(out, ngBlock(blockIdb, idb)...)
+	for i, frame := range frames {
+		ticks := uint64(1000+i)*1e9 + 5e8
+		epb := binary.LittleEndian.AppendUint32(nil, 0)
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(ticks>>32))
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(ticks))
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(len(frame)))
+		epb = binary.LittleEndian.AppendUint32(epb, uint32(len(frame)))
+		out = append(out, ngBlock(blockEpb, append(epb, frame...))...)
+	}
+	return out
+}
+
+// testFrames returns an unwanted datagram, then testTs in RTP on 235.35.3.5:3535.
+func testFrames() [][]byte {
+	group := [4]byte{235, 35, 3, 5}
+	return [][]byte{
+		udpFrame(group, 9999, testTs(testCues(2)...)),
+		udpFrame(group, 3535, rtpDgram(7, testTs(testCues(1)...))),
+	}
+}
+
+func TestDecodePcap(t *testing.T) {
+	captures := map[string][]byte{
+		"pcap":   testPcap(65535, testFrames()...),
+		"pcapng": testPcapng(testFrames()...),
+	}
+	for name, capture := range captures {
+		t.Run(name, func(t *testing.T) {
+			stream := NewStream()
+			stream.Quiet = true
+			cues, err := stream.DecodePcapReader(bytes.NewReader(capture), "235.35.3.5:3535")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cues) != 1 {
+				t.Fatalf("got %d Cues, want 1", len(cues))
+			}
+			if ct := cues[0].PacketData.CaptureTime; math.Abs(ct-1001.5) > 1e-6 {
+				t.Fatalf("CaptureTime is %v, want 1001.5", ct)
+			}
+			if stats := stream.RtpStats(); stats == nil || stats.Packets != 1 {
+				t.Fatalf("RtpStats is %+v", stats)
+			}
+			// every datagram
+			stream = NewStream()
+			stream.Quiet = true
+			stream.NoRtp = true
+			cues, err = stream.DecodePcapReader(bytes.NewReader(capture), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cues) != 3 || stream.RtpStats() != nil {
+				t.Fatalf("got %d Cues and RtpStats %+v, want 3 and nil", len(cues), stream.RtpStats())
+			}
+		})
+	}
+}
+
+func TestDecodePcapBadCaplen(t *testing.T) {
+	frame := udpFrame([4]byte{235, 35, 3, 5}, 3535, testTs())
+	huge := testPcap(65535, frame)
+	// caplen in the first record
+	binary.LittleEndian.PutUint32(huge[24+8:], 0x7fffffff)
+	short := testPcap(64, frame)
+	for name, capture := range map[string][]byte{"over the maximum": huge, "over snaplen": short} {
+		stream := NewStream()
+		if _, err := stream.DecodePcapReader(bytes.NewReader(capture), ""); err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+	ng := testPcapng(frame)
+	// block length of the EPB, after the 28 byte SHB and 32 byte IDB
+	binary.LittleEndian.PutUint32(ng[28+32+4:], 0x7ffffff0)
+	stream := NewStream()
+	if _, err := stream.DecodePcapReader(bytes.NewReader(ng), ""); err == nil {
+		t.Error("pcapng: no error for a huge block")
+	}
+}
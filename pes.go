@@ -0,0 +1,102 @@
+package cuei
+
+// videoTypes are PMT stream types for video.
+var videoTypes = []uint8{0x01, 0x02, 0x10, 0x1b, 0x20, 0x24, 0x33, 0x42, 0xea}
+
+// isVideo returns true if streamtype is video.
+func isVideo(streamtype uint8) bool {
+	for _, vt := range videoTypes {
+		if vt == streamtype {
+			return true
+		}
+	}
+	return false
+}
+
+// pesTimes holds the PTS and DTS from a PES header, in 90k ticks.
+type pesTimes struct {
+	pts    uint64
+	dts    uint64
+	hasPts bool
+	hasDts bool
+}
+
+/*
+parsePesHeader parses the PES header at the start of pay for PTS and DTS.
+
+Stream IDs without the optional PES header, like padding
+and private_stream_2, have no timestamps. A DTS is only
+present with a PTS, when it is missing the DTS equals the PTS.
+*/
+func parsePesHeader(pay []byte) (pesTimes, bool) {
+	var pt pesTimes
+	if len(pay) < 9 || pay[0] != 0 || pay[1] != 0 || pay[2] != 1 {
+		return pt, false
+	}
+	switch pay[3] {
+	case 0xbc, 0xbe, 0xbf, 0xf0, 0xf1, 0xf2, 0xf8, 0xff:
+		return pt, false
+	}
+	if pay[6]>>6 != 2 {
+		return pt, false
+	}
+	flags := pay[7] >> 6
+	head := pay[9:]
+	if int(pay[8]) < len(head) {
+		head = head[:pay[8]]
+	}
+	if flags&2 == 2 && len(head) >= 5 {
+		pt.pts = pesTime(head[0:5])
+		pt.dts = pt.pts
+		pt.hasPts = true
+	}
+	if flags == 3 && len(head) >= 10 {
+		pt.dts = pesTime(head[5:10])
+		pt.hasDts = true
+	}
+	return pt, pt.hasPts
+}
+
+// pesTime parses a 33 bit PTS or DTS.
+func pesTime(bites []byte) uint64 {
+	ts := uint64(bites[0]>>1&0x07) << 30
+	ts |= uint64(bites[1]) << 22
+	ts |= uint64(bites[2]>>1) << 15
+	ts |= uint64(bites[3]) << 7
+	ts |= uint64(bites[4] >> 1)
+	return ts
+}
+
+// parsePts parses a PES header for PTS and DTS, by pid.
+func (stream *Stream) parsePts(pay []byte, pid uint16) {
+	prgm, ok := stream.Pid2Prgm[pid]
+	if !ok {
+		return
+	}
+	pt, ok := parsePesHeader(pay)
+	if !ok {
+		return
+	}
+	stream.Pid2Pts[pid] = pt.pts
+	stream.Pid2Dts[pid] = pt.dts
+	if ref, ok := stream.refPid(prgm); !ok || ref == pid {
+		stream.Prgm2Pts[prgm] = pt.pts
+		timeline(stream.Prgm2Line, prgm).Unwrap(Pts(pt.pts))
+	}
+}
+
+/*
+refPid returns the PID used for the PTS of Cues in program prgm.
+That's Stream.PtsPid when it's in prgm, or the first video PID.
+It returns false for programs without either,
+and any PID with a PTS is used.
+*/
+func (stream *Stream) refPid(prgm uint16) (uint16, bool) {
+	if stream.PtsPid != 0 {
+		if p, ok := stream.Pid2Prgm[stream.PtsPid]; ok && p == prgm {
+			return stream.PtsPid, true
+		}
+	}
+	vid, ok := stream.prgm2Vid[prgm]
+	return vid, ok
+}
@@ -0,0 +1,106 @@
+package cuei
+
+import (
+	"testing"
+)
+
+// pesTs encodes ts as a 5 byte PES timestamp with the 4 bit prefix.
+func pesTs(prefix byte, ts uint64) []byte {
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0e | 1,
+		byte(ts >> 22),
+		byte(ts>>14) | 1,
+		byte(ts >> 7),
+		byte(ts<<1) | 1,
+	}
+}
+
+// pesHead returns a PES header for stream id sid, with a DTS when dts isn't zero.
+func pesHead(sid byte, pts uint64, dts uint64) []byte {
+	head := []byte{0, 0, 1, sid, 0, 0, 0x80, 0x80, 5}
+	head = append(head, pesTs(2, pts)...)
+	if dts != 0 {
+		head[7], head[8] = 0xc0, 10
+		head[9] |= 0x10
+		head = append(head, pesTs(1, dts)...)
+	}
+	return head
+}
+
+func TestParsePesHeader(t *testing.T) {
+	noPts := []byte{0, 0, 1, 0xe0, 0, 0, 0x80, 0x00, 0}
+	padding := pesHead(0xbe, 900, 0)
+	mpeg1 := pesHead(0xe0, 900, 0)
+	mpeg1[6] = 0x0f
+	short := pesHead(0xe0, 900, 0)[:11]
+	stuffed := pesHead(0xe0, 900, 0)
+	stuffed[8] = 8
+	stuffed = append(stuffed, 0xff, 0xff, 0xff)
+	tests := []struct {
+		name string
+		pay  []byte
+		ok   bool
+		pts  uint64
+		dts  uint64
+	}{
+		{"pts", pesHead(0xe0, 900, 0), true, 900, 900},
+		{"pts and dts", pesHead(0xe0, 9000, 6000), true, 9000, 6000},
+		{"33 bits", pesHead(0xc0, 1<<33-1, 0), true, 1<<33 - 1, 1<<33 - 1},
+		{"33 bit dts", pesHead(0xe0, 3000, 1<<32+5), true, 3000, 1<<32 + 5},
+		{"stuffing after the pts", stuffed, true, 900, 900},
+		{"no pts", noPts, false, 0, 0},
+		{"padding stream", padding, false, 0, 0},
+		{"no optional header", mpeg1, false, 0, 0},
+		{"header cut short", short, false, 0, 0},
+		{"not a PES", []byte{0, 0, 2, 0xe0, 0, 0, 0x80, 0x80, 5, 0, 0, 0, 0, 0}, false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt, ok := parsePesHeader(tt.pay)
+			if ok != tt.ok || pt.pts != tt.pts || pt.dts != tt.dts {
+				t.Fatalf("got %v, pts %d, dts %d, want %v, %d, %d", ok, pt.pts, pt.dts, tt.ok, tt.pts, tt.dts)
+			}
+		})
+	}
+}
+
+func TestPesPidPts(t *testing.T) {
+	const audPid = 0x101
+	pat := finishSection([]byte{0x00, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0, 0x00, 0x01, 0xe0, testPmtPid})
+	pmt := finishSection([]byte{0x02, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0x00,
+		0x1b, 0xe1, 0x00, 0xf0, 0x00, 0x0f, 0xe1, 0x01, 0xf0, 0x00, 0x86, 0xe1, 0x02, 0xf0, 0x00})
+	ts, _ := packetize(pat, 0, 0)
+	pkts, _ := packetize(pmt, testPmtPid, 0)
+	ts = append(ts, pkts...)
+	// video then audio, the audio PTS must not replace the video PTS
+	pkts, _ = packetizeUnit(pesHead(0xe0, 90000, 87000), testVidPid, 0)
+	ts = append(ts, pkts...)
+	pkts, _ = packetizeUnit(pesHead(0xc0, 180000, 0), audPid, 0)
+	ts = append(ts, pkts...)
+	pkts, _ = packetize(decB64(testCue), testScte35Pid, 0)
+	ts = append(ts, pkts...)
+	tests := []struct {
+		ptsPid uint16
+		pts    uint64
+		dts    uint64
+	}{
+		{0, 90000, 87000},
+		{audPid, 180000, 180000},
+	}
+	for _, tt := range tests {
+		stream := NewStream()
+		stream.Quiet = true
+		stream.PtsPid = tt.ptsPid
+		cues := stream.DecodeBytes(ts)
+		if len(cues) != 1 {
+			t.Fatalf("PtsPid %#x: got %d Cues, want 1", tt.ptsPid, len(cues))
+		}
+		pd := cues[0].PacketData
+		if pd.Pts != mk90k(tt.pts) || pd.Dts != mk90k(tt.dts) {
+			t.Fatalf("PtsPid %#x: Pts %v Dts %v, want %v %v", tt.ptsPid, pd.Pts, pd.Dts, mk90k(tt.pts), mk90k(tt.dts))
+		}
+		if stream.Pid2Pts[testVidPid] != 90000 || stream.Pid2Pts[audPid] != 180000 || stream.Pid2Dts[testVidPid] != 87000 {
+			t.Fatalf("PtsPid %#x: Pid2Pts %v Pid2Dts %v", tt.ptsPid, stream.Pid2Pts, stream.Pid2Dts)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package cuei
+
+import (
+	"fmt"
+)
+
+// PMT descriptor tags
+const (
+	registrationTag  = 0x05
+	languageTag      = 0x0a
+	streamIDTag      = 0x52
+	cueIdentifierTag = 0x8a
+)
+
+// cueiFormat is the Registration Descriptor format_identifier for SCTE-35.
+const cueiFormat = "CUEI"
+
+// maxPmtDescriptors limits the descriptors parsed from one loop.
+const maxPmtDescriptors = 64
+
+/*
+PmtDescriptor is a program or elementary stream descriptor from a PMT.
+
+	Registration Descriptors set FormatIdentifier
+	Stream Identifier Descriptors set ComponentTag
+	ISO 639 Language Descriptors set Languages
+	Cue Identifier Descriptors set CueStreamType
+
+Other descriptors only have Tag and Data.
+*/
+type PmtDescriptor struct {
+	Tag              uint8
+	Name             string   `json:",omitempty"`
+	FormatIdentifier string   `json:",omitempty"`
+	ComponentTag     uint8    `json:",omitempty"`
+	Languages        []string `json:",omitempty"`
+	CueStreamType    *uint8   `json:",omitempty"`
+	Data             []byte   `json:",omitempty"`
+}
+
+// Json returns the PmtDescriptor as JSON
+func (pd *PmtDescriptor) Json() string {
+	return mkJson(pd)
+}
+
+// Show prints the PmtDescriptor as JSON
+func (pd *PmtDescriptor) Show() {
+	fmt.Println(pd.Json())
+}
+
+// parsePmtDescriptors parses a PMT descriptor loop.
+func parsePmtDescriptors(bites []byte) []PmtDescriptor {
+	var dscptrs []PmtDescriptor
+	for len(bites) >= 2 && len(dscptrs) < maxPmtDescriptors {
+		tag := bites[0]
+		dlen := int(bites[1])
+		if 2+dlen > len(bites) {
+			break
+		}
+		data := bites[2 : 2+dlen]
+		bites = bites[2+dlen:]
+		pd := PmtDescriptor{Tag: tag}
+		switch tag {
+		case registrationTag:
+			pd.Name = "Registration Descriptor"
+			if dlen >= 4 {
+				pd.FormatIdentifier = string(data[:4])
+			}
+		case languageTag:
+			pd.Name = "ISO 639 Language Descriptor"
+			for i := 0; i+4 <= dlen; i += 4 {
+				pd.Languages = append(pd.Languages, string(data[i:i+3]))
+			}
+		case streamIDTag:
+			pd.Name = "Stream Identifier Descriptor"
+			if dlen >= 1 {
+				pd.ComponentTag = data[0]
+			}
+		case cueIdentifierTag:
+			pd.Name = "Cue Identifier Descriptor"
+			if dlen >= 1 {
+				cst := data[0]
+				pd.CueStreamType = &cst
+			}
+		default:
+			pd.Data = append([]byte(nil), data...)
+		}
+		dscptrs = append(dscptrs, pd)
+	}
+	return dscptrs
+}
+
+// hasCuei returns true for a CUEI Registration Descriptor or a Cue Identifier Descriptor.
+func hasCuei(dscptrs []PmtDescriptor) bool {
+	for _, pd := range dscptrs {
+		if pd.FormatIdentifier == cueiFormat || pd.Tag == cueIdentifierTag {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+isScte35 returns true if an elementary stream carries SCTE-35.
+
+Stream type 0x86 is SCTE-35. Stream type 0x06, private PES,
+is also used for subtitles, teletext and AC-3, so it's only SCTE-35
+with a CUEI Registration Descriptor or a Cue Identifier Descriptor
+in its own descriptors, dscptrs. A CUEI Registration Descriptor
+in the program loop registers the program's 0x86 streams,
+it doesn't make every 0x06 stream in the program SCTE-35.
+*/
+func isScte35(streamtype uint8, dscptrs []PmtDescriptor) bool {
+	switch streamtype {
+	case scte35Type:
+		return true
+	case 0x06:
+		return hasCuei(dscptrs)
+	}
+	return false
+}
+
+// Stream.CueStreamCheck values
+const (
+	CueStreamIgnore = iota // don't check cue_stream_type
+	CueStreamFlag          // set Cue.PacketData.CueStreamTypeError
+	CueStreamReject        // drop the Cue
+)
+
+/*
+cueStreamCmds are the splice commands allowed by each cue_stream_type.
+
+	0x00 splice_insert, splice_null, splice_schedule
+	0x01 all commands
+	0x02 segmentation, time_signal
+	0x03 tiered splicing, splice_insert, splice_null, splice_schedule
+	0x04 tiered segmentation, time_signal
+
+splice_null is allowed on every type, as a heartbeat.
+Reserved and user defined types aren't checked.
+*/
+var cueStreamCmds = map[uint8][]uint16{
+	0x00: {0x00, 0x04, 0x05},
+	0x02: {0x00, 0x06},
+	0x03: {0x00, 0x04, 0x05},
+	0x04: {0x00, 0x06},
+}
+
+// cueStreamType returns the cue_stream_type for pid, if it has a Cue Identifier Descriptor.
+func (stream *Stream) cueStreamType(pid uint16) (uint8, bool) {
+	for _, pd := range stream.Pid2Dscptrs[pid] {
+		if pd.CueStreamType != nil {
+			return *pd.CueStreamType, true
+		}
+	}
+	return 0, false
+}
+
+// cueAllowed returns false if the cue_stream_type of pid doesn't allow the Cue's command.
+func (stream *Stream) cueAllowed(cue *Cue, pid uint16) bool {
+	cst, ok := stream.cueStreamType(pid)
+	if !ok || cue.Command == nil {
+		return true
+	}
+	cmds, ok := cueStreamCmds[cst]
+	if !ok {
+		return true
+	}
+	return IsIn(cmds, uint16(cue.Command.CommandType))
+}
+
+/*
+checkCueStream applies Stream.CueStreamCheck to a Cue from pid,
+it returns false if the Cue is rejected.
+*/
+func (stream *Stream) checkCueStream(cue *Cue, pid uint16) bool {
+	if stream.CueStreamCheck == CueStreamIgnore || stream.cueAllowed(cue, pid) {
+		return true
+	}
+	if stream.CueStreamCheck == CueStreamReject {
+		return false
+	}
+	cue.PacketData.CueStreamTypeError = true
+	return true
+}
@@ -0,0 +1,165 @@
+package cuei
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestParsePmtDescriptors(t *testing.T) {
+	cst := uint8(0x02)
+	tests := []struct {
+		name  string
+		bites []byte
+		want  []PmtDescriptor
+	}{
+		{"registration", []byte{0x05, 0x04, 'C', 'U', 'E', 'I'},
+			[]PmtDescriptor{{Tag: 0x05, Name: "Registration Descriptor", FormatIdentifier: "CUEI"}}},
+		{"short registration", []byte{0x05, 0x02, 'C', 'U'},
+			[]PmtDescriptor{{Tag: 0x05, Name: "Registration Descriptor"}}},
+		{"languages", []byte{0x0a, 0x08, 'e', 'n', 'g', 0, 's', 'p', 'a', 0},
+			[]PmtDescriptor{{Tag: 0x0a, Name: "ISO 639 Language Descriptor", Languages: []string{"eng", "spa"}}}},
+		{"stream identifier", []byte{0x52, 0x01, 0x07},
+			[]PmtDescriptor{{Tag: 0x52, Name: "Stream Identifier Descriptor", ComponentTag: 7}}},
+		{"cue identifier", []byte{0x8a, 0x01, 0x02},
+			[]PmtDescriptor{{Tag: 0x8a, Name: "Cue Identifier Descriptor", CueStreamType: &cst}}},
+		{"other", []byte{0x0e, 0x03, 1, 2, 3},
+			[]PmtDescriptor{{Tag: 0x0e, Data: []byte{1, 2, 3}}}},
+		{"two", []byte{0x52, 0x01, 0x07, 0x8a, 0x01, 0x02},
+			[]PmtDescriptor{{Tag: 0x52, Name: "Stream Identifier Descriptor", ComponentTag: 7},
+				{Tag: 0x8a, Name: "Cue Identifier Descriptor", CueStreamType: &cst}}},
+		{"truncated", []byte{0x52, 0x01, 0x07, 0x05, 0x04, 'C', 'U'},
+			[]PmtDescriptor{{Tag: 0x52, Name: "Stream Identifier Descriptor", ComponentTag: 7}}},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsePmtDescriptors(tt.bites); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %v, want %v", mkJson(got), mkJson(tt.want))
+			}
+		})
+	}
+}
+
+func TestIsScte35(t *testing.T) {
+	cuei := parsePmtDescriptors(cueiReg)
+	cueID := parsePmtDescriptors([]byte{0x8a, 0x01, 0x00})
+	ac3 := parsePmtDescriptors([]byte{0x6a, 0x01, 0x00})
+	other := parsePmtDescriptors([]byte{0x05, 0x04, 'A', 'C', '-', '3'})
+	tests := []struct {
+		name       string
+		streamtype uint8
+		es         []PmtDescriptor
+		want       bool
+	}{
+		{"0x86", 0x86, nil, true},
+		{"0x06", 0x06, nil, false},
+		{"0x06 with CUEI", 0x06, cuei, true},
+		{"0x06 with a cue identifier", 0x06, cueID, true},
+		{"0x06 AC-3", 0x06, ac3, false},
+		{"0x06 with another registration", 0x06, other, false},
+		{"video with CUEI", 0x1b, cuei, false},
+	}
+	for _, tt := range tests {
+		if got := isScte35(tt.streamtype, tt.es); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProgramCuei(t *testing.T) {
+	// a CUEI registration in the program loop, AC-3 on 0x101 as private PES, SCTE-35 on 0x102
+	pmt := finishSection([]byte{0x02, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0x06,
+		0x05, 0x04, 'C', 'U', 'E', 'I',
+		0x1b, 0xe1, 0x00, 0xf0, 0x00,
+		0x06, 0xe1, 0x01, 0xf0, 0x03, 0x6a, 0x01, 0x00,
+		0x86, 0xe1, 0x02, 0xf0, 0x00})
+	pat := finishSection([]byte{0x00, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0, 0x00, 0x01, 0xe0, testPmtPid})
+	ts, _ := packetize(pat, 0, 0)
+	pkts, _ := packetize(pmt, testPmtPid, 0)
+	ts = append(ts, pkts...)
+	audio := ccPkt(0x101, 0, "p")
+	pkts, _ = packetize(decB64(testCue), testScte35Pid, 0)
+	in := append(append(append([]byte(nil), ts...), audio...), pkts...)
+	cues, stream := decodeTs(in)
+	if len(cues) != 1 || !reflect.DeepEqual(stream.Pids.Scte35Pids, []uint16{testScte35Pid}) {
+		t.Fatalf("got %d Cues, SCTE-35 PIDs %v", len(cues), stream.Pids.Scte35Pids)
+	}
+	if len(stream.Prgm2Dscptrs[1]) != 1 || stream.Prgm2Dscptrs[1][0].FormatIdentifier != "CUEI" {
+		t.Fatalf("program descriptors are %v", mkJson(stream.Prgm2Dscptrs[1]))
+	}
+	// the Filter keeps the audio
+	var out bytes.Buffer
+	cues, stream = decodeTs(filterTs(t, NewFilter(&out), &out, in))
+	if len(cues) != 0 || stream.Pid2Stats[0x101] == nil || stream.Pid2Stats[0x101].Packets != 1 {
+		t.Fatalf("got %d Cues, audio stats %+v", len(cues), stream.Pid2Stats[0x101])
+	}
+	if stream.Pid2Type[0x101] != 0x06 {
+		t.Fatalf("PMT streams are %v", stream.Pid2Type)
+	}
+	// the Muxer uses the 0x86 PID, not the audio
+	mux := NewMuxer(io.Discard)
+	mux.Write(ts)
+	if mux.pid != testScte35Pid {
+		t.Fatalf("Muxer PID is %#x, want %#x", mux.pid, testScte35Pid)
+	}
+}
+
+// cueStreamTs returns MPEGTS with SCTE-35 on testScte35Pid with es descriptors, carrying secs.
+func cueStreamTs(es []byte, secs ...[]byte) []byte {
+	pat := finishSection([]byte{0x00, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0, 0x00, 0x01, 0xe0, testPmtPid})
+	pmt := []byte{0x02, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0x00,
+		0x1b, 0xe1, 0x00, 0xf0, 0x00, 0x86, 0xe1, 0x02, 0xf0, byte(len(es))}
+	pmt = finishSection(append(pmt, es...))
+	ts, _ := packetize(pat, 0, 0)
+	pkts, _ := packetize(pmt, testPmtPid, 0)
+	ts = append(ts, pkts...)
+	cc := uint8(0)
+	for _, sec := range secs {
+		pkts, cc = packetize(sec, testScte35Pid, cc)
+		ts = append(ts, pkts...)
+	}
+	return ts
+}
+
+func TestCueStreamCheck(t *testing.T) {
+	null := finishSection([]byte{0xfc, 0x30, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xf0, 0x00, 0x00, 0x00, 0x00})
+	// splice null, splice insert, time signal
+	secs := [][]byte{null, decB64(dtmfCue), decB64(testCue)}
+	tests := []struct {
+		name    string
+		es      []byte
+		check   int
+		found   int
+		flagged int
+	}{
+		{"no cue identifier", nil, CueStreamReject, 3, 0},
+		{"insert, null, schedule, ignored", []byte{0x8a, 1, 0x00}, CueStreamIgnore, 3, 0},
+		{"insert, null, schedule, flagged", []byte{0x8a, 1, 0x00}, CueStreamFlag, 3, 1},
+		{"insert, null, schedule, rejected", []byte{0x8a, 1, 0x00}, CueStreamReject, 2, 0},
+		{"all commands", []byte{0x8a, 1, 0x01}, CueStreamReject, 3, 0},
+		{"segmentation, flagged", []byte{0x8a, 1, 0x02}, CueStreamFlag, 3, 1},
+		{"segmentation, rejected", []byte{0x8a, 1, 0x02}, CueStreamReject, 2, 0},
+		{"tiered splicing, rejected", []byte{0x8a, 1, 0x03}, CueStreamReject, 2, 0},
+		{"tiered segmentation, rejected", []byte{0x8a, 1, 0x04}, CueStreamReject, 2, 0},
+		{"user defined", []byte{0x8a, 1, 0x80}, CueStreamReject, 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewStream()
+			stream.Quiet = true
+			stream.CueStreamCheck = tt.check
+			cues := stream.DecodeBytes(cueStreamTs(tt.es, secs...))
+			flagged := 0
+			for _, cue := range cues {
+				if cue.PacketData.CueStreamTypeError {
+					flagged++
+				}
+			}
+			if len(cues) != tt.found || flagged != tt.flagged {
+				t.Fatalf("found %d, flagged %d, want %d, %d", len(cues), flagged, tt.found, tt.flagged)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package cuei
+
+import (
+	"math"
+)
+
+// PtsWrap is where 33 bit PTS, DTS, PCR base and splice times roll over, about 26.5 hours.
+const PtsWrap = 1 << 33
+
+/*
+Pts is a 33 bit time in 90k ticks, it wraps at PtsWrap.
+
+Use Pts for arithmetic and comparisons on splice times,
+PtsAdjustment, PTS and PCR, so they are right across the rollover.
+*/
+type Pts uint64
+
+// NewPts converts seconds to a Pts, wrapping negative values and values past PtsWrap.
+func NewPts(seconds float64) Pts {
+	ticks := int64(math.Round(seconds * 90000.0))
+	return Pts(uint64(ticks) & (PtsWrap - 1))
+}
+
+// Seconds returns the Pts in seconds.
+func (pts Pts) Seconds() float64 {
+	return mk90k(uint64(pts))
+}
+
+// Add returns pts plus seconds, wrapped.
+func (pts Pts) Add(seconds float64) Pts {
+	return pts.AddPts(NewPts(seconds))
+}
+
+// AddPts returns pts plus other, wrapped.
+func (pts Pts) AddPts(other Pts) Pts {
+	return (pts + other) & (PtsWrap - 1)
+}
+
+/*
+Sub returns pts minus other in seconds, the shortest way around,
+from -PtsWrap/2 to PtsWrap/2 ticks.
+
+The result is rounded to the microsecond, the precision of Seconds
+and the times in Cue.PacketData, so 3003 ticks is 0.033367,
+not 0.03336666666666667.
+*/
+func (pts Pts) Sub(other Pts) float64 {
+	return math.Round(float64(pts.diff(other))/90000.0*1000000) / 1000000
+}
+
+// diff returns pts minus other in ticks, the shortest way around.
+func (pts Pts) diff(other Pts) int64 {
+	d := int64((pts - other) & (PtsWrap - 1))
+	if d >= PtsWrap/2 {
+		d -= PtsWrap
+	}
+	return d
+}
+
+// Before returns true if pts is before other.
+func (pts Pts) Before(other Pts) bool {
+	return pts.diff(other) < 0
+}
+
+// After returns true if pts is after other.
+func (pts Pts) After(other Pts) bool {
+	return pts.diff(other) > 0
+}
+
+/*
+Timeline unwraps a PTS or PCR into monotonic seconds
+for streams that run past the rollover.
+
+The first time passed to Timeline.Unwrap sets the start,
+later times count up from it, jumps backwards count down.
+*/
+type Timeline struct {
+	started bool
+	last    Pts
+	ticks   int64
+}
+
+// Unwrap returns pts in seconds on the unwrapped timeline.
+func (tl *Timeline) Unwrap(pts Pts) float64 {
+	if !tl.started {
+		tl.started = true
+		tl.ticks = int64(pts)
+	} else {
+		tl.ticks += pts.diff(tl.last)
+	}
+	tl.last = pts
+	return tl.Now()
+}
+
+// Now returns the latest time on the unwrapped timeline in seconds.
+func (tl *Timeline) Now() float64 {
+	return float64(tl.ticks) / 90000.0
+}
+
+// timeline returns the Timeline for program prgm in lines, adding it if needed.
+func timeline(lines map[uint16]*Timeline, prgm uint16) *Timeline {
+	line, ok := lines[prgm]
+	if !ok {
+		line = &Timeline{}
+		lines[prgm] = line
+	}
+	return line
+}
@@ -0,0 +1,90 @@
+package cuei
+
+import (
+	"testing"
+)
+
+func TestPtsWrap(t *testing.T) {
+	late := Pts(PtsWrap - 90000)
+	early := Pts(90000)
+	if got := early.Sub(late); got != 2.0 {
+		t.Fatalf("Sub across the wrap is %v, want 2", got)
+	}
+	if got := late.Sub(early); got != -2.0 {
+		t.Fatalf("Sub back across the wrap is %v, want -2", got)
+	}
+	if !late.Before(early) || !early.After(late) {
+		t.Fatal("Before and After are wrong across the wrap")
+	}
+	if got := late.Add(3.0); got != Pts(180000) {
+		t.Fatalf("Add across the wrap is %v, want 180000", got)
+	}
+	if got := NewPts(-1.0); got != Pts(PtsWrap-90000) {
+		t.Fatalf("NewPts(-1) is %v", got)
+	}
+}
+
+func TestTimeline(t *testing.T) {
+	tests := []struct {
+		name string
+		pts  []Pts
+		want float64
+	}{
+		{"steady", []Pts{90000, 180000, 270000}, 3.0},
+		{"across the wrap", []Pts{PtsWrap - 90000, 0, 90000}, float64(PtsWrap+90000) / 90000},
+		{"twice across the wrap", []Pts{PtsWrap - 90000, 90000, PtsWrap / 2, PtsWrap - 90000, 90000}, float64(2*PtsWrap+90000) / 90000},
+		{"backwards across the wrap", []Pts{90000, PtsWrap - 90000}, -1.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tl Timeline
+			var got float64
+			for _, pts := range tt.pts {
+				got = tl.Unwrap(pts)
+			}
+			if got != tt.want || tl.Now() != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// pcrPkt returns a packet on pid with a PCR of base 90k ticks, in the adaptation field.
+func pcrPkt(pid uint16, cc uint8, base uint64) []byte {
+	pkt := []byte{syncByte, byte(pid >> 8 & 0x1f), byte(pid), 0x20 | cc&0x0f, 183, 0x10}
+	pkt = append(pkt, byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1), byte(base<<7)|0x7e, 0)
+	for len(pkt) < pktSz {
+		pkt = append(pkt, 0xff)
+	}
+	return pkt
+}
+
+func TestStreamTimelines(t *testing.T) {
+	// PTS and PCR from 2 seconds before the wrap, then 1 second after it
+	times := []uint64{PtsWrap - 180000, 90000}
+	ts := testTs()
+	for i, tick := range times {
+		// the PCR packets have no payload, the CC doesn't count up
+		ts = append(ts, pcrPkt(testVidPid, uint8(i), tick)...)
+		pkts, _ := packetizeUnit(pesHead(0xe0, tick, 0), testVidPid, uint8(i+1))
+		ts = append(ts, pkts...)
+		pkts, _ = packetize(decB64(testCue), testScte35Pid, uint8(i))
+		ts = append(ts, pkts...)
+	}
+	stream := NewStream()
+	stream.Quiet = true
+	cues := stream.DecodeBytes(ts)
+	if len(cues) != 2 {
+		t.Fatalf("got %d Cues, want 2", len(cues))
+	}
+	start := float64(PtsWrap-180000) / 90000
+	for i, want := range []float64{start, start + 3} {
+		pd := cues[i].PacketData
+		if pd.Timeline != want || pd.PcrTimeline != want {
+			t.Fatalf("Cue %d: Timeline %v PcrTimeline %v, want %v", i, pd.Timeline, pd.PcrTimeline, want)
+		}
+	}
+	if cues[1].PacketData.Pts != 1.0 || cues[1].PacketData.Pcr != 1.0 {
+		t.Fatalf("Pts %v Pcr %v, want 1", cues[1].PacketData.Pts, cues[1].PacketData.Pcr)
+	}
+}
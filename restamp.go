@@ -0,0 +1,82 @@
+package cuei
+
+import (
+	"io"
+)
+
+/*
+Restamper re-stamps SCTE-35 in MPEGTS for remuxing and time shifting.
+
+MPEGTS written to a Restamper is passed through to the io.Writer
+given to NewRestamper. Each SCTE-35 Cue has Restamper.Offset seconds,
+or the change Restamper.Map makes to its splice time,
+added to its pts_adjustment. Only pts_adjustment and the CRC_32
+are changed in the section, it's re-packetized, all other packets are unchanged.
+188, 192 and 204 byte packets are found like Stream.DecodeBytes does.
+*/
+type Restamper struct {
+	Offset float64                   // Offset is seconds added to each Cue's PtsAdjustment.
+	Map    func(pts float64) float64 // Map returns the new splice time for a splice time, used instead of Offset when set.
+	stream *Stream
+	pw     *pktWriter
+	cues   *cueRewriter // cues re-packetizes re-stamped Cues
+	ccs    counters     // ccs are continuity counters for rewritten PIDs
+}
+
+// NewRestamper initializes and returns a *Restamper writing to wr, adding offset seconds.
+func NewRestamper(wr io.Writer, offset float64) *Restamper {
+	rs := &Restamper{Offset: offset}
+	rs.stream = NewStream()
+	rs.stream.Quiet = true
+	rs.stream.Handle(func(*Cue) {})
+	rs.pw = newPktWriter(rs.stream, wr, rs.restamp)
+	rs.ccs = make(counters)
+	rs.cues = newCueRewriter(rs.stream, rs.ccs)
+	return rs
+}
+
+/*
+Write re-stamps the MPEGTS in p, so a Restamper can be used with io.Copy.
+p doesn't need to end on a packet boundary.
+*/
+func (rs *Restamper) Write(p []byte) (int, error) {
+	return rs.pw.Write(p)
+}
+
+// Flush writes any partial packet left from Write.
+func (rs *Restamper) Flush() error {
+	return rs.pw.Flush()
+}
+
+// restamp parses pkt and returns what to write for it.
+func (rs *Restamper) restamp(pkt []byte) []byte {
+	rs.stream.parse(pkt)
+	pid := parsePid(pkt[1], pkt[2])
+	if !rs.stream.Pids.isScte35Pid(pid) {
+		return pkt
+	}
+	rs.ccs.seed(pkt, pid)
+	return rs.cues.rewrite(pkt, pid, rs.adjust)
+}
+
+/*
+adjust adds the offset to pts_adjustment in the section and returns it
+with a new CRC_32. The section isn't re-encoded, so descriptors
+and commands are kept byte for byte.
+*/
+func (rs *Restamper) adjust(cue *Cue, sec []byte) []byte {
+	offset := rs.Offset
+	if rs.Map != nil {
+		pts := cue.splicePts()
+		offset = NewPts(rs.Map(pts)).Sub(NewPts(pts))
+	}
+	if len(sec) < 13 {
+		return sec
+	}
+	adj := uint64(sec[4]&0x01)<<32 | uint64(sec[5])<<24 | uint64(sec[6])<<16 | uint64(sec[7])<<8 | uint64(sec[8])
+	adj = uint64(Pts(adj).Add(offset))
+	out := append([]byte(nil), sec[:len(sec)-4]...)
+	out[4] = out[4]&0xfe | byte(adj>>32)
+	out[5], out[6], out[7], out[8] = byte(adj>>24), byte(adj>>16), byte(adj>>8), byte(adj)
+	return appendCrc32(out)
+}
@@ -0,0 +1,69 @@
+package cuei
+
+import (
+	"bytes"
+	"testing"
+)
+
+// restampTs re-stamps ts with rs and returns the Cues in the output.
+func restampTs(t *testing.T, rs *Restamper, out *bytes.Buffer, ts []byte) []*Cue {
+	t.Helper()
+	writeChunks(t, rs, ts, 1000)
+	if err := rs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	cues, _ := decodeTs(out.Bytes())
+	return cues
+}
+
+func TestRestamperDtmf(t *testing.T) {
+	sec := decB64(dtmfCue)
+	var out bytes.Buffer
+	cues := restampTs(t, NewRestamper(&out, 10.0), &out, testTs(sec))
+	if len(cues) != 1 {
+		t.Fatalf("got %d Cues, want 1", len(cues))
+	}
+	// only pts_adjustment, 10 seconds is 900000 ticks, and the CRC_32 change
+	want := append([]byte(nil), sec[:len(sec)-4]...)
+	want[6], want[7], want[8] = 0x0d, 0xbb, 0xa0
+	want = appendCrc32(want)
+	pkts, _ := packetize(want, testScte35Pid, 0)
+	if got := out.Bytes()[2*pktSz:]; !bytes.Equal(got, pkts) {
+		t.Fatalf("got %x\nwant %x", got, pkts)
+	}
+	dscptr := cues[0].Descriptors[0]
+	if dscptr.Tag != 1 || dscptr.DTMFChars == 0 || cues[0].InfoSection.PtsAdjustment != 10.0 {
+		t.Fatalf("Cue is %v", mkJson(cues[0]))
+	}
+}
+
+func TestRestamper(t *testing.T) {
+	insert := decB64(dtmfCue)
+	tests := []struct {
+		name   string
+		offset float64
+		fn     func(float64) float64
+		want   float64
+	}{
+		{"offset", 10.0, nil, 10.0},
+		{"negative offset wraps", -1.0, nil, NewPts(-1.0).Seconds()},
+		{"map", 0, func(pts float64) float64 { return pts + 2.5 }, 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			rs := NewRestamper(&out, tt.offset)
+			rs.Map = tt.fn
+			ts := testTs(decB64(testCue), insert)
+			cues := restampTs(t, rs, &out, ts)
+			if len(cues) != 2 || out.Len() != len(ts) {
+				t.Fatalf("got %d Cues in %d bytes", len(cues), out.Len())
+			}
+			for _, cue := range cues {
+				if got := cue.InfoSection.PtsAdjustment; got != tt.want {
+					t.Fatalf("PtsAdjustment is %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
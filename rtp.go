@@ -0,0 +1,380 @@
+package cuei
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// rtpHeadSz is the size of a RTP header without CSRCs or extensions.
+const rtpHeadSz = 12
+
+// fecHeadSz is the size of a SMPTE 2022-1 FEC header.
+const fecHeadSz = 16
+
+// rtpHistory is how many released RTP payloads are kept for FEC recovery.
+const rtpHistory = 1024
+
+/*
+rtpMaxGap is how far past the reorder depth a sequence number
+can jump ahead before the receiver resyncs, like after a sender restart.
+Jumps back further than rtpHistory also resync.
+*/
+const rtpMaxGap = 1024
+
+// RtpStats counts RTP packets and sequence errors.
+type RtpStats struct {
+	Packets    uint64
+	Lost       uint64
+	Reordered  uint64
+	Duplicates uint64
+	Recovered  uint64 // recovered by FEC
+	Resyncs    uint64 // sequence number jumps, the gap isn't counted as lost
+}
+
+// fecPacket is a SMPTE 2022-1 FEC packet.
+type fecPacket struct {
+	snBase   uint16
+	offset   uint16
+	na       uint16
+	lenRecov uint16
+	payload  []byte
+}
+
+// covers returns true if seq is protected by fec.
+func (fec *fecPacket) covers(seq uint16) bool {
+	for i := uint16(0); i < fec.na; i++ {
+		if fec.snBase+i*fec.offset == seq {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+rtpReceiver puts RTP payloads back in sequence order.
+
+Up to depth packets are held to fix reordering
+and to give FEC a chance to recover lost packets.
+Packets arriving after they were counted lost are
+released right away and counted as reordered.
+Sequence numbers that jump further than depth + rtpMaxGap ahead,
+or rtpHistory back, restart the sequence.
+*/
+type rtpReceiver struct {
+	sync.Mutex
+	stats   RtpStats
+	depth   int
+	started bool
+	base    uint16 // base is the first sequence number since the start or a resync
+	next    uint16
+	highest uint16
+	pending map[uint16][]byte
+	history map[uint16][]byte
+	fecs    []*fecPacket
+}
+
+// newRtpReceiver initializes and returns a *rtpReceiver
+func newRtpReceiver(depth int) *rtpReceiver {
+	rr := &rtpReceiver{depth: depth}
+	rr.pending = make(map[uint16][]byte)
+	rr.history = make(map[uint16][]byte)
+	return rr
+}
+
+// push adds a RTP payload and returns payloads ready for parsing, in order.
+func (rr *rtpReceiver) push(seq uint16, payload []byte) [][]byte {
+	rr.Lock()
+	defer rr.Unlock()
+	rr.stats.Packets++
+	var out [][]byte
+	gap := int(int16(seq - rr.next))
+	if rr.started && (gap > rr.depth+rtpMaxGap || gap < -rtpHistory) {
+		out = rr.resync()
+		gap = 0
+	}
+	if !rr.started {
+		rr.started = true
+		rr.base = seq
+		rr.next = seq
+		rr.highest = seq
+	}
+	if gap < 0 {
+		return rr.late(seq, payload)
+	}
+	if _, ok := rr.pending[seq]; ok {
+		rr.stats.Duplicates++
+		return nil
+	}
+	if int16(seq-rr.highest) < 0 {
+		rr.stats.Reordered++
+	} else {
+		rr.highest = seq
+	}
+	rr.pending[seq] = append([]byte(nil), payload...)
+	return append(out, rr.release(false)...)
+}
+
+/*
+late handles a packet behind the next one to release,
+a duplicate if it was released, otherwise it was counted lost.
+*/
+func (rr *rtpReceiver) late(seq uint16, payload []byte) [][]byte {
+	_, released := rr.history[seq]
+	if released || int16(seq-rr.base) < 0 || rr.stats.Lost == 0 {
+		rr.stats.Duplicates++
+		return nil
+	}
+	rr.stats.Lost--
+	rr.stats.Reordered++
+	payload = append([]byte(nil), payload...)
+	rr.history[seq] = payload
+	return [][]byte{payload}
+}
+
+// resync releases everything pending and restarts the sequence with the next packet.
+func (rr *rtpReceiver) resync() [][]byte {
+	out := rr.release(true)
+	rr.stats.Resyncs++
+	rr.started = false
+	rr.history = make(map[uint16][]byte)
+	rr.fecs = nil
+	return out
+}
+
+/*
+release returns pending payloads in order, skipping over
+missing packets held longer than depth, or all of them when flush is true.
+*/
+func (rr *rtpReceiver) release(flush bool) [][]byte {
+	var out [][]byte
+	for rr.started && int16(rr.highest-rr.next) >= 0 {
+		payload, ok := rr.pending[rr.next]
+		if !ok {
+			if !flush && int(int16(rr.highest-rr.next)) < rr.depth {
+				break
+			}
+			payload, ok = rr.recover(rr.next)
+			if !ok {
+				missing := rr.missing()
+				rr.stats.Lost += uint64(missing)
+				rr.advance(missing)
+				continue
+			}
+			rr.stats.Recovered++
+		}
+		delete(rr.pending, rr.next)
+		rr.history[rr.next] = payload
+		out = append(out, payload)
+		rr.advance(1)
+	}
+	rr.pruneFec()
+	return out
+}
+
+/*
+missing returns how many packets from next are missing,
+up to the first pending packet, or the first one FEC might recover.
+*/
+func (rr *rtpReceiver) missing() uint16 {
+	gap := rr.highest - rr.next + 1
+	for seq := range rr.pending {
+		if d := seq - rr.next; d < gap {
+			gap = d
+		}
+	}
+	for _, fec := range rr.fecs {
+		for i := uint16(0); i < fec.na; i++ {
+			if d := fec.snBase + i*fec.offset - rr.next; d > 0 && d < gap {
+				gap = d
+			}
+		}
+	}
+	return gap
+}
+
+// advance moves next on by n, dropping history older than rtpHistory.
+func (rr *rtpReceiver) advance(n uint16) {
+	rr.next += n
+	if n == 1 {
+		delete(rr.history, rr.next-rtpHistory-1)
+		return
+	}
+	for seq := range rr.history {
+		if rr.next-seq > rtpHistory {
+			delete(rr.history, seq)
+		}
+	}
+}
+
+// flush releases all pending payloads.
+func (rr *rtpReceiver) flush() [][]byte {
+	rr.Lock()
+	defer rr.Unlock()
+	return rr.release(true)
+}
+
+// addFec adds a SMPTE 2022-1 FEC packet, the RTP header already removed.
+func (rr *rtpReceiver) addFec(data []byte) {
+	if len(data) < fecHeadSz {
+		return
+	}
+	fec := &fecPacket{
+		snBase:   binary.BigEndian.Uint16(data[0:2]),
+		lenRecov: binary.BigEndian.Uint16(data[2:4]),
+		offset:   uint16(data[13]),
+		na:       uint16(data[14]),
+		payload:  append([]byte(nil), data[fecHeadSz:]...),
+	}
+	if fec.na == 0 || fec.offset == 0 {
+		return
+	}
+	rr.Lock()
+	defer rr.Unlock()
+	rr.fecs = append(rr.fecs, fec)
+}
+
+/*
+recover rebuilds a missing payload from a FEC packet
+when every other packet it protects is available.
+*/
+func (rr *rtpReceiver) recover(seq uint16) ([]byte, bool) {
+	for _, fec := range rr.fecs {
+		if !fec.covers(seq) {
+			continue
+		}
+		payload := append([]byte(nil), fec.payload...)
+		length := fec.lenRecov
+		ok := true
+		for i := uint16(0); i < fec.na && ok; i++ {
+			sn := fec.snBase + i*fec.offset
+			if sn == seq {
+				continue
+			}
+			other, found := rr.history[sn]
+			if !found {
+				other, found = rr.pending[sn]
+			}
+			if !found {
+				ok = false
+				break
+			}
+			length ^= uint16(len(other))
+			for j := 0; j < len(other) && j < len(payload); j++ {
+				payload[j] ^= other[j]
+			}
+		}
+		if ok && int(length) <= len(payload) {
+			return payload[:length], true
+		}
+	}
+	return nil, false
+}
+
+// pruneFec drops FEC packets that only protect released packets.
+func (rr *rtpReceiver) pruneFec() {
+	n := 0
+	for _, fec := range rr.fecs {
+		last := fec.snBase + (fec.na-1)*fec.offset
+		if int16(last-rr.next) >= 0 {
+			rr.fecs[n] = fec
+			n++
+		}
+	}
+	rr.fecs = rr.fecs[:n]
+}
+
+/*
+rtpPayload splits a RTP packet into sequence number and payload,
+skipping CSRCs, header extensions and padding.
+*/
+func rtpPayload(dgram []byte) (uint16, []byte, bool) {
+	if len(dgram) < rtpHeadSz || dgram[0]>>6 != 2 {
+		return 0, nil, false
+	}
+	head := rtpHeadSz + int(dgram[0]&0x0f)*4
+	if dgram[0]&0x10 == 0x10 {
+		if len(dgram) < head+4 {
+			return 0, nil, false
+		}
+		head += 4 + int(binary.BigEndian.Uint16(dgram[head+2:head+4]))*4
+	}
+	end := len(dgram)
+	if dgram[0]&0x20 == 0x20 {
+		end -= int(dgram[end-1])
+	}
+	if head > end {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint16(dgram[2:4]), dgram[head:end], true
+}
+
+// isRtp returns true if dgram is RTP carrying MPEGTS.
+func isRtp(dgram []byte) bool {
+	if len(dgram) == 0 || dgram[0] == 0x47 {
+		return false
+	}
+	_, pay, ok := rtpPayload(dgram)
+	return ok && len(pay) > 0 && pay[0] == 0x47
+}
+
+// rtpRcvr returns the Stream's rtpReceiver, making it if needed.
+func (stream *Stream) rtpRcvr() *rtpReceiver {
+	if stream.rtp == nil {
+		stream.rtp = newRtpReceiver(stream.RtpDepth)
+	}
+	return stream.rtp
+}
+
+/*
+DecodeDatagram parses a UDP datagram for SCTE-35.
+
+RTP headers are detected and removed, and RTP sequence numbers
+are tracked in Stream.RtpStats. Set Stream.RtpDepth to hold packets
+for reordering and FEC recovery, or Stream.NoRtp to leave RTP headers
+for sync to skip over.
+*/
+func (stream *Stream) DecodeDatagram(dgram []byte) []*Cue {
+	if stream.NoRtp || !isRtp(dgram) {
+		return stream.DecodeBytes(dgram)
+	}
+	seq, pay, _ := rtpPayload(dgram)
+	var cues []*Cue
+	for _, p := range stream.rtpRcvr().push(seq, pay) {
+		cues = append(cues, stream.DecodeBytes(p)...)
+	}
+	return cues
+}
+
+/*
+DecodeFec adds a SMPTE 2022-1 column or row FEC datagram,
+used to recover lost RTP packets passed to Stream.DecodeDatagram.
+*/
+func (stream *Stream) DecodeFec(dgram []byte) {
+	_, pay, ok := rtpPayload(dgram)
+	if ok {
+		stream.rtpRcvr().addFec(pay)
+	}
+}
+
+// RtpStats returns RTP packet counts, nil if no RTP has been seen.
+func (stream *Stream) RtpStats() *RtpStats {
+	if stream.rtp == nil {
+		return nil
+	}
+	stream.rtp.Lock()
+	defer stream.rtp.Unlock()
+	stats := stream.rtp.stats
+	return &stats
+}
+
+// flushRtp parses any RTP payloads still held for reordering.
+func (stream *Stream) flushRtp() []*Cue {
+	if stream.rtp == nil {
+		return nil
+	}
+	var cues []*Cue
+	for _, p := range stream.rtp.flush() {
+		cues = append(cues, stream.DecodeBytes(p)...)
+	}
+	return cues
+}
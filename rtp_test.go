@@ -0,0 +1,175 @@
+package cuei
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// rtpPay returns a test payload for seq, the length varies for FEC length recovery.
+func rtpPay(seq uint16) []byte {
+	pay := make([]byte, 10+int(seq%5))
+	for i := range pay {
+		pay[i] = byte(seq) + byte(i)*7
+	}
+	binary.BigEndian.PutUint16(pay, seq)
+	return pay
+}
+
+// mkFec returns a SMPTE 2022-1 FEC payload protecting na packets from snBase, offset apart.
+func mkFec(snBase uint16, offset uint8, na uint8) []byte {
+	var lenRecov uint16
+	var xor []byte
+	for i := uint16(0); i < uint16(na); i++ {
+		pay := rtpPay(snBase + i*uint16(offset))
+		lenRecov ^= uint16(len(pay))
+		for len(xor) < len(pay) {
+			xor = append(xor, 0)
+		}
+		for j, b := range pay {
+			xor[j] ^= b
+		}
+	}
+	fec := make([]byte, fecHeadSz)
+	binary.BigEndian.PutUint16(fec[0:2], snBase)
+	binary.BigEndian.PutUint16(fec[2:4], lenRecov)
+	fec[13] = offset
+	fec[14] = na
+	return append(fec, xor...)
+}
+
+// pushAll pushes seqs and returns the sequence numbers of the payloads released, after a flush.
+func pushAll(t *testing.T, rr *rtpReceiver, seqs ...uint16) []uint16 {
+	t.Helper()
+	var out [][]byte
+	for _, seq := range seqs {
+		out = append(out, rr.push(seq, rtpPay(seq))...)
+	}
+	out = append(out, rr.flush()...)
+	var got []uint16
+	for _, pay := range out {
+		seq := binary.BigEndian.Uint16(pay)
+		if !bytes.Equal(pay, rtpPay(seq)) {
+			t.Fatalf("payload for %d is %x, want %x", seq, pay, rtpPay(seq))
+		}
+		got = append(got, seq)
+	}
+	return got
+}
+
+// seqRange returns n sequence numbers from start.
+func seqRange(start uint16, n int) []uint16 {
+	var seqs []uint16
+	for i := 0; i < n; i++ {
+		seqs = append(seqs, start+uint16(i))
+	}
+	return seqs
+}
+
+func TestRtpReorder(t *testing.T) {
+	rr := newRtpReceiver(4)
+	got := pushAll(t, rr, 0, 1, 3, 2, 5, 4, 6)
+	if !equalSeqs(got, seqRange(0, 7)) {
+		t.Fatalf("released %v", got)
+	}
+	if rr.stats.Reordered != 2 || rr.stats.Lost != 0 || rr.stats.Duplicates != 0 {
+		t.Fatalf("stats %+v", rr.stats)
+	}
+}
+
+func TestRtpLoss(t *testing.T) {
+	rr := newRtpReceiver(2)
+	got := pushAll(t, rr, 0, 1, 3, 4, 5, 6)
+	if !equalSeqs(got, []uint16{0, 1, 3, 4, 5, 6}) || rr.stats.Lost != 1 {
+		t.Fatalf("released %v, stats %+v", got, rr.stats)
+	}
+	// 2 turns up after it was counted lost, then 3 again
+	if out := rr.push(2, rtpPay(2)); len(out) != 1 {
+		t.Fatal("late packet wasn't released")
+	}
+	if out := rr.push(3, rtpPay(3)); len(out) != 0 {
+		t.Fatal("duplicate was released")
+	}
+	if rr.stats.Lost != 0 || rr.stats.Reordered != 1 || rr.stats.Duplicates != 1 {
+		t.Fatalf("stats %+v", rr.stats)
+	}
+	// a burst of 499 lost packets, skipped at once
+	rr = newRtpReceiver(0)
+	pushAll(t, rr, 0, 500)
+	if rr.stats.Lost != 499 || rr.stats.Resyncs != 0 {
+		t.Fatalf("stats %+v", rr.stats)
+	}
+}
+
+func TestRtpJump(t *testing.T) {
+	tests := []struct {
+		name  string
+		depth int
+		jump  uint16
+	}{
+		{"forward", 0, 40000},
+		{"forward with depth", 32, 40000},
+		{"back", 0, 50},
+		{"past the wrap", 8, 65530},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := newRtpReceiver(tt.depth)
+			seqs := append(seqRange(5000, 10), seqRange(tt.jump, 1000)...)
+			got := pushAll(t, rr, seqs...)
+			if len(got) != len(seqs) {
+				t.Fatalf("released %d payloads, want %d", len(got), len(seqs))
+			}
+			if rr.stats.Duplicates != 0 || rr.stats.Lost != 0 || rr.stats.Resyncs != 1 {
+				t.Fatalf("stats %+v", rr.stats)
+			}
+		})
+	}
+}
+
+func TestRtpFec(t *testing.T) {
+	// a 4 x 4 matrix, sequence numbers 0 to 15
+	tests := []struct {
+		name string
+		fecs [][]byte
+		drop []uint16
+	}{
+		{"column", [][]byte{mkFec(0, 4, 4), mkFec(1, 4, 4), mkFec(2, 4, 4), mkFec(3, 4, 4)}, []uint16{5, 6}},
+		{"row", [][]byte{mkFec(0, 1, 4), mkFec(4, 1, 4), mkFec(8, 1, 4), mkFec(12, 1, 4)}, []uint16{5, 10}},
+		{"column and row", [][]byte{mkFec(1, 4, 4), mkFec(4, 1, 4)}, []uint16{5, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := newRtpReceiver(16)
+			for _, fec := range tt.fecs {
+				rr.addFec(fec)
+			}
+			var seqs []uint16
+			for _, seq := range seqRange(0, 16) {
+				if !IsIn(tt.drop, seq) {
+					seqs = append(seqs, seq)
+				}
+			}
+			got := pushAll(t, rr, seqs...)
+			if !equalSeqs(got, seqRange(0, 16)) {
+				t.Fatalf("released %v", got)
+			}
+			if rr.stats.Recovered != 2 || rr.stats.Lost != 0 {
+				t.Fatalf("stats %+v", rr.stats)
+			}
+		})
+	}
+}
+
+// equalSeqs compares sequence number slices.
+func equalSeqs(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package cuei
+
+import (
+	"fmt"
+	"unicode/utf16"
+)
+
+// Service information PIDs
+const (
+	sdtPid = 0x11   // DVB Service Description Table
+	vctPid = 0x1ffb // ATSC PSIP base PID, Virtual Channel Tables
+)
+
+// Service information table ids
+const (
+	sdtTable  = 0x42 // SDT, actual transport stream
+	tvctTable = 0xc8 // Terrestrial Virtual Channel Table
+	cvctTable = 0xc9 // Cable Virtual Channel Table
+)
+
+// serviceTag is the DVB service_descriptor tag.
+const serviceTag = 0x48
+
+/*
+Service is the channel name for a program,
+from a DVB SDT service_descriptor or an ATSC VCT.
+
+	DVB sets Name and Provider
+	ATSC sets Name, the short_name, and Major and Minor channel numbers
+
+Undecoded is set when a DVB Name or Provider uses a character table
+cuei doesn't decode, that field holds the bytes as they are.
+*/
+type Service struct {
+	Name      string `json:",omitempty"`
+	Provider  string `json:",omitempty"`
+	Major     uint16 `json:",omitempty"`
+	Minor     uint16 `json:",omitempty"`
+	Undecoded bool   `json:",omitempty"`
+}
+
+// Json returns the Service as JSON
+func (svc *Service) Json() string {
+	return mkJson(svc)
+}
+
+// Show prints the Service as JSON
+func (svc *Service) Show() {
+	fmt.Println(svc.Json())
+}
+
+// parseSdt parses a DVB SDT section for service names.
+func (stream *Stream) parseSdt(sec []byte, pid uint16) {
+	if sec[0] != sdtTable || len(sec) < 15 || stream.sameAsLast(sec, pid) || !crcOk(sec) {
+		return
+	}
+	end := len(sec) - 4 //  4 bytes for crc
+	idx := 11
+	for idx+5 <= end {
+		prgm := parsePrgm(sec[idx], sec[idx+1])
+		dlen := int(parseLen(sec[idx+3], sec[idx+4]))
+		idx += 5
+		if idx+dlen > end {
+			dlen = end - idx
+		}
+		stream.serviceDescriptor(sec[idx:idx+dlen], prgm)
+		idx += dlen
+	}
+}
+
+// serviceDescriptor sets Stream.Prgm2Service from a service_descriptor in a SDT descriptor loop.
+func (stream *Stream) serviceDescriptor(bites []byte, prgm uint16) {
+	for len(bites) >= 2 {
+		tag := bites[0]
+		dlen := int(bites[1])
+		if 2+dlen > len(bites) {
+			return
+		}
+		data := bites[2 : 2+dlen]
+		bites = bites[2+dlen:]
+		if tag != serviceTag || dlen < 3 {
+			continue
+		}
+		plen := int(data[1])
+		if 3+plen > dlen {
+			continue
+		}
+		provider := data[2 : 2+plen]
+		name := data[3+plen:]
+		if nlen := int(data[2+plen]); nlen < len(name) {
+			name = name[:nlen]
+		}
+		svc := &Service{}
+		var nameOk, providerOk bool
+		svc.Name, nameOk = dvbString(name)
+		svc.Provider, providerOk = dvbString(provider)
+		svc.Undecoded = !nameOk || !providerOk
+		stream.Prgm2Service[prgm] = svc
+	}
+}
+
+// dvbTables are the DVB character table bytes for ISO/IEC 8859 parts.
+var dvbTables = map[uint8]uint8{
+	0x01: 5, 0x02: 6, 0x03: 7, 0x04: 8, 0x05: 9, 0x06: 10,
+	0x07: 11, 0x09: 13, 0x0a: 14, 0x0b: 15,
+}
+
+/*
+dvbString decodes a DVB text field, ETSI EN 300 468 Annex A.
+
+Text without a character table byte is read as Latin-1,
+tables 0x01 to 0x0b and 0x10 are ISO/IEC 8859 parts,
+0x11 is UCS-2 and 0x15 is UTF-8, control codes are dropped.
+Other tables, like KS X 1001, GB 2312 and Big5, aren't decoded,
+the bytes are returned as they are, with false.
+*/
+func dvbString(bites []byte) (string, bool) {
+	if len(bites) == 0 {
+		return "", true
+	}
+	part := uint8(1)
+	switch tbl := bites[0]; {
+	case tbl >= 0x20:
+	case tbl == 0x15:
+		return string(bites[1:]), true
+	case tbl == 0x11:
+		return ucs2String(bites[1:]), true
+	case tbl == 0x10 && len(bites) >= 3 && bites[1] == 0 && (bites[2] == 1 || iso8859[bites[2]] != nil):
+		part = bites[2]
+		bites = bites[3:]
+	case dvbTables[tbl] != 0:
+		part = dvbTables[tbl]
+		bites = bites[1:]
+	default:
+		return string(bites), false
+	}
+	runes := make([]rune, 0, len(bites))
+	for _, b := range bites {
+		r := rune(b)
+		if b < 0x20 || (b >= 0x80 && b < 0xa0) {
+			continue
+		}
+		if b >= 0xa0 && part != 1 {
+			if r = iso8859[part][b-0xa0]; r == 0xfffd {
+				continue
+			}
+		}
+		runes = append(runes, r)
+	}
+	return string(runes), true
+}
+
+// ucs2String decodes big endian UCS-2 DVB text, without control codes.
+func ucs2String(bites []byte) string {
+	units := make([]uint16, 0, len(bites)/2)
+	for i := 0; i+1 < len(bites); i += 2 {
+		unit := uint16(bites[i])<<8 | uint16(bites[i+1])
+		// DVB control codes are 0xe080 to 0xe09f in UCS-2
+		if unit < 0x20 || (unit >= 0x80 && unit < 0xa0) || (unit >= 0xe080 && unit < 0xe0a0) {
+			continue
+		}
+		units = append(units, unit)
+	}
+	return string(utf16.Decode(units))
+}
+
+// parseVct parses an ATSC TVCT or CVCT section for channel names.
+func (stream *Stream) parseVct(sec []byte, pid uint16) {
+	if (sec[0] != tvctTable && sec[0] != cvctTable) || len(sec) < 14 || stream.sameAsLast(sec, pid) || !crcOk(sec) {
+		return
+	}
+	end := len(sec) - 4 //  4 bytes for crc
+	channels := int(sec[9])
+	chunksize := 32
+	idx := 10
+	for i := 0; i < channels && idx+chunksize <= end; i++ {
+		ch := sec[idx : idx+chunksize]
+		tsid := parsePrgm(ch[22], ch[23])
+		prgm := parsePrgm(ch[24], ch[25])
+		if prgm != 0 && (!stream.patSeen || tsid == stream.tsid) {
+			stream.Prgm2Service[prgm] = &Service{
+				Name:  shortName(ch[:14]),
+				Major: uint16(ch[14]&0x0f)<<6 | uint16(ch[15]>>2),
+				Minor: uint16(ch[15]&0x03)<<8 | uint16(ch[16]),
+			}
+		}
+		idx += chunksize + int(parseLen(ch[30], ch[31]))&0x3ff
+	}
+}
+
+// shortName decodes an ATSC short_name, seven UTF-16 code units padded with zeros.
+func shortName(bites []byte) string {
+	units := make([]uint16, 0, len(bites)/2)
+	for i := 0; i+1 < len(bites); i += 2 {
+		unit := uint16(bites[i])<<8 | uint16(bites[i+1])
+		if unit == 0 {
+			break
+		}
+		units = append(units, unit)
+	}
+	return string(utf16.Decode(units))
+}
@@ -0,0 +1,161 @@
+package cuei
+
+import (
+	"testing"
+)
+
+// sdtService is a program with a service_descriptor for sdtSec.
+type sdtService struct {
+	prgm           uint16
+	provider, name []byte
+}
+
+// sdtSec returns a SDT section for tsid with services.
+func sdtSec(tsid uint16, services ...sdtService) []byte {
+	sec := []byte{sdtTable, 0xf0, 0, byte(tsid >> 8), byte(tsid), 0xc1, 0, 0, 0x00, 0x01, 0xff}
+	for _, svc := range services {
+		dscptr := []byte{serviceTag, 0, 0x01, byte(len(svc.provider))}
+		dscptr = append(dscptr, svc.provider...)
+		dscptr = append(dscptr, byte(len(svc.name)))
+		dscptr = append(dscptr, svc.name...)
+		dscptr[1] = byte(len(dscptr) - 2)
+		// another descriptor first, then the service_descriptor
+		loop := append([]byte{0x5f, 0x04, 0, 0, 0, 0x28}, dscptr...)
+		sec = append(sec, byte(svc.prgm>>8), byte(svc.prgm), 0xfc, 0x80|byte(len(loop)>>8), byte(len(loop)))
+		sec = append(sec, loop...)
+	}
+	return finishSection(sec)
+}
+
+// vctChannel returns a VCT channel for tsid and prgm, with descriptors.
+func vctChannel(name string, major, minor, tsid, prgm uint16, dscptrs ...byte) []byte {
+	ch := make([]byte, 32)
+	for i, r := range []rune(name) {
+		ch[2*i], ch[2*i+1] = byte(r>>8), byte(r)
+	}
+	ch[14] = 0xf0 | byte(major>>6)
+	ch[15] = byte(major&0x3f)<<2 | byte(minor>>8)
+	ch[16] = byte(minor)
+	ch[22], ch[23], ch[24], ch[25] = byte(tsid>>8), byte(tsid), byte(prgm>>8), byte(prgm)
+	ch[30], ch[31] = 0xfc|byte(len(dscptrs)>>8), byte(len(dscptrs))
+	return append(ch, dscptrs...)
+}
+
+// vctSec returns a VCT section with channels.
+func vctSec(table byte, channels ...[]byte) []byte {
+	sec := []byte{table, 0xf0, 0, 0x00, 0x07, 0xc1, 0, 0, 0, byte(len(channels))}
+	for _, ch := range channels {
+		sec = append(sec, ch...)
+	}
+	// no additional descriptors
+	return finishSection(append(sec, 0xfc, 0))
+}
+
+func TestDvbString(t *testing.T) {
+	tests := []struct {
+		bites []byte
+		want  string
+		ok    bool
+	}{
+		{[]byte("BBC One"), "BBC One", true},
+		{[]byte{'C', 'a', 'f', 0xe9}, "Café", true},
+		{[]byte{0x05, 'O', 'n', 'e', 0x86, '!', 0x87}, "One!", true},
+		{[]byte{0x01, 0xbf, 0xd5, 0xe0, 0xd2, 0xeb, 0xd9}, "Первый", true},
+		{[]byte{0x02, 0xc7, 0xe4, 0xcc, 0xd2, 0xea, 0xd1, 0xc9}, "الجزيرة", true},
+		{[]byte{0x03, 0xc5, 0xd1, 0xd4}, "ΕΡΤ", true},
+		{[]byte{0x04, 0xeb, 0xe0, 0xef}, "כאן", true},
+		{[]byte{0x07, 0xe4, 0xb7, 0xc2}, "ไทย", true},
+		{[]byte{0x09, 0xd4, 'g', 'a', 'k', 'i'}, "Ōgaki", true},
+		{[]byte{0x0b, 0xa4, '5'}, "€5", true},
+		// 0xa1 isn't assigned in ISO/IEC 8859-6
+		{[]byte{0x02, 0xa1, 0xc7}, "ا", true},
+		{[]byte{0x10, 0x00, 0x05, 0xbf, 0xd5, 0xe0, 0xd2, 0xeb, 0xd9}, "Первый", true},
+		{[]byte{0x10, 0x00, 0x01, 'T', 'V', 0xe9}, "TVé", true},
+		{[]byte{0x11, 0x04, 0x20, 0x04, 0x3e, 0x04, 0x41, 0xe0, 0x8a, 0x04, 0x41, 0x04, 0x38, 0x04, 0x4f, 0x00}, "Россия", true},
+		{[]byte{0x15, 0xe2, 0x98, 0x85, ' ', 'T', 'V'}, "★ TV", true},
+		{[]byte{0x13, 0xd6, 0xd0, 0xce, 0xc4}, "\x13\xd6\xd0\xce\xc4", false},
+		{[]byte{0x1f, 0x01, 'T', 'V'}, "\x1f\x01TV", false},
+		{[]byte{0x08, 'T', 'V'}, "\x08TV", false},
+		{[]byte{0x10, 0x00, 0x0c, 'T', 'V'}, "\x10\x00\x0cTV", false},
+		{nil, "", true},
+	}
+	for _, tt := range tests {
+		if got, ok := dvbString(tt.bites); got != tt.want || ok != tt.ok {
+			t.Errorf("dvbString(%x) is %q, %v, want %q, %v", tt.bites, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseSdt(t *testing.T) {
+	stream := NewStream()
+	sec := sdtSec(7,
+		sdtService{1, []byte("BBC"), []byte{0x05, 'O', 'n', 'e', 0xe9, 0x86}},
+		sdtService{2, nil, []byte("Two")},
+		sdtService{3, []byte{0x01, 0xb2, 0xb3, 0xc2, 0xc0, 0xba}, []byte{0x01, 0xbf, 0xd5, 0xe0, 0xd2, 0xeb, 0xd9}},
+		sdtService{4, []byte("CCTV"), []byte{0x13, 0xd6, 0xd0, 0xce, 0xc4}})
+	stream.parseSdt(sec, sdtPid)
+	want := map[uint16]Service{
+		1: {Name: "Oneé", Provider: "BBC"},
+		2: {Name: "Two"},
+		3: {Name: "Первый", Provider: "ВГТРК"},
+		4: {Name: "\x13\xd6\xd0\xce\xc4", Provider: "CCTV", Undecoded: true},
+	}
+	for prgm, svc := range want {
+		if got := stream.Prgm2Service[prgm]; got == nil || *got != svc {
+			t.Fatalf("program %d: got %v, want %v", prgm, got, mkJson(&svc))
+		}
+	}
+	// a bad CRC is ignored
+	stream = NewStream()
+	sec[len(sec)-1] ^= 0xff
+	stream.parseSdt(sec, sdtPid)
+	if len(stream.Prgm2Service) != 0 {
+		t.Fatal("SDT with a bad CRC was parsed")
+	}
+}
+
+func TestParseVct(t *testing.T) {
+	tests := []struct {
+		name    string
+		sec     []byte
+		patTsid uint16
+		want    map[uint16]Service
+	}{
+		{"terrestrial", vctSec(tvctTable,
+			vctChannel("KQED", 9, 1, 7, 1, 0xa0, 0x02, 0, 0),
+			vctChannel("KQED-HD", 9, 2, 7, 2)),
+			7, map[uint16]Service{1: {Name: "KQED", Major: 9, Minor: 1}, 2: {Name: "KQED-HD", Major: 9, Minor: 2}}},
+		{"cable, one part", vctSec(cvctTable, vctChannel("Ω", 1023, 1023, 7, 3)),
+			0, map[uint16]Service{3: {Name: "Ω", Major: 1023, Minor: 1023}}},
+		{"another transport stream", vctSec(tvctTable, vctChannel("WNET", 13, 1, 8, 1), vctChannel("KQED", 9, 1, 7, 2)),
+			7, map[uint16]Service{2: {Name: "KQED", Major: 9, Minor: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := NewStream()
+			if tt.patTsid != 0 {
+				stream.tsid, stream.patSeen = tt.patTsid, true
+			}
+			stream.parseVct(tt.sec, vctPid)
+			if len(stream.Prgm2Service) != len(tt.want) {
+				t.Fatalf("got %d services, want %d", len(stream.Prgm2Service), len(tt.want))
+			}
+			for prgm, svc := range tt.want {
+				if got := stream.Prgm2Service[prgm]; got == nil || *got != svc {
+					t.Fatalf("program %d: got %v, want %v", prgm, got, mkJson(&svc))
+				}
+			}
+		})
+	}
+}
+
+func TestCueServiceName(t *testing.T) {
+	ts := testTs()
+	pkts, _ := packetize(sdtSec(0, sdtService{1, []byte("PBS"), []byte("KQED")}), sdtPid, 0)
+	ts = append(ts, pkts...)
+	pkts, _ = packetize(decB64(testCue), testScte35Pid, 0)
+	cues, _ := decodeTs(append(ts, pkts...))
+	if len(cues) != 1 || cues[0].PacketData.ServiceName != "KQED" || cues[0].PacketData.ServiceProvider != "PBS" {
+		t.Fatalf("got %d Cues", len(cues))
+	}
+}
@@ -0,0 +1,132 @@
+package cuei
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+SourceFunc opens source for reading MPEGTS.
+
+source is the full url passed to Stream.Decode or Stream.DecodeContext.
+The returned io.ReadCloser is closed when decoding is done.
+*/
+type SourceFunc func(ctx context.Context, source string) (io.ReadCloser, error)
+
+var (
+	sourcesMu sync.RWMutex
+	sources   = map[string]SourceFunc{
+		"file":  openFile,
+		"udp":   openUdp,
+		"tcp":   openTcp,
+		"http":  openHttp,
+		"https": openHttp,
+	}
+)
+
+/*
+RegisterSource registers fn to open sources with scheme,
+like "srt" for srt://host:port. Registering an existing
+scheme replaces it.
+
+Built in schemes:
+
+	file://path         a local file
+	udp://@group:port   multicast
+	udp://host:port     unicast, bind to host:port, rcvbuf sets the receive buffer
+	tcp://host:port     connect to host:port
+	http(s)://...       progressive MPEGTS over HTTP
+
+A source of "-" is stdin.
+Anything else is a local file name.
+*/
+func RegisterSource(scheme string, fn SourceFunc) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources[strings.ToLower(scheme)] = fn
+}
+
+// sourceFunc returns the SourceFunc for the scheme of source, if any.
+func sourceFunc(source string) (SourceFunc, bool) {
+	scheme, _, ok := strings.Cut(source, "://")
+	if !ok {
+		return nil, false
+	}
+	sourcesMu.RLock()
+	defer sourcesMu.RUnlock()
+	fn, ok := sources[strings.ToLower(scheme)]
+	return fn, ok
+}
+
+// openFile opens file:// urls.
+func openFile(ctx context.Context, source string) (io.ReadCloser, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	path := u.Path
+	if u.Host != "" && u.Host != "localhost" {
+		path = u.Host + path
+	}
+	return os.Open(path)
+}
+
+/*
+openUdp binds to unicast udp://host:port urls,
+rcvbuf sets the socket receive buffer, like multicast urls.
+*/
+func openUdp(ctx context.Context, source string) (io.ReadCloser, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	rcvbuf, err := queryInt(u.Query(), "rcvbuf", 0)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if rcvbuf > 0 {
+		if err = conn.SetReadBuffer(rcvbuf); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// openTcp connects to tcp://host:port urls.
+func openTcp(ctx context.Context, source string) (io.ReadCloser, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", strings.TrimPrefix(source, "tcp://"))
+}
+
+// openHttp requests http:// and https:// urls.
+func openHttp(ctx context.Context, source string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%v: %v", source, resp.Status)
+	}
+	return resp.Body, nil
+}
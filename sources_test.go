@@ -0,0 +1,134 @@
+package cuei
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeSource decodes source and returns how many Cues were found.
+func decodeSource(t *testing.T, source string) int {
+	t.Helper()
+	stream := NewStream()
+	stream.Quiet = true
+	cues, err := stream.DecodeContext(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(cues)
+}
+
+func TestFileSource(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "cues.ts")
+	if err := os.WriteFile(fname, testTs(testCues(2)...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{fname, "file://" + fname} {
+		if n := decodeSource(t, source); n != 2 {
+			t.Errorf("%v: got %d Cues, want 2", source, n)
+		}
+	}
+}
+
+func TestTcpSource(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write(testTs(testCues(2)...))
+		conn.Close()
+	}()
+	if n := decodeSource(t, "tcp://"+ln.Addr().String()); n != 2 {
+		t.Fatalf("got %d Cues, want 2", n)
+	}
+}
+
+func TestHttpSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cues.ts" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(testTs(testCues(2)...))
+	}))
+	defer srv.Close()
+	if n := decodeSource(t, srv.URL+"/cues.ts"); n != 2 {
+		t.Fatalf("got %d Cues, want 2", n)
+	}
+	stream := NewStream()
+	if _, err := stream.DecodeContext(context.Background(), srv.URL+"/missing.ts"); err == nil {
+		t.Fatal("no error for a 404")
+	}
+}
+
+func TestUdpSource(t *testing.T) {
+	probe, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := probe.LocalAddr().String()
+	probe.Close()
+	stream := NewStream()
+	stream.Quiet = true
+	found := make(chan *Cue, 10)
+	stream.Handle(func(cue *Cue) { found <- cue })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := stream.DecodeContext(ctx, "udp://"+addr+"?rcvbuf=262144")
+		errc <- err
+	}()
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ts := testTs(testCues(1)...)
+	for {
+		conn.Write(ts)
+		select {
+		case <-found:
+			cancel()
+			if err := <-errc; err != context.Canceled {
+				t.Fatalf("got %v, want %v", err, context.Canceled)
+			}
+			return
+		case err := <-errc:
+			t.Fatal(err)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func TestRegisterSource(t *testing.T) {
+	var opened string
+	RegisterSource("Test", func(ctx context.Context, source string) (io.ReadCloser, error) {
+		opened = source
+		return io.NopCloser(strings.NewReader(string(testTs(testCues(3)...)))), nil
+	})
+	defer func() {
+		sourcesMu.Lock()
+		delete(sources, "test")
+		sourcesMu.Unlock()
+	}()
+	if n := decodeSource(t, "test://anything"); n != 3 {
+		t.Fatalf("got %d Cues, want 3", n)
+	}
+	if opened != "test://anything" {
+		t.Fatalf("SourceFunc got %q", opened)
+	}
+}
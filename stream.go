@@ -2,23 +2,50 @@ package cuei
 
 import (
 	"bytes"
-    "fmt"
+	"context"
 	"io"
 	"net"
 	"os"
-	"strings"
+	"sync"
+	"time"
 )
 
 // packetData holds information about the packet carrying a SCTE-35
 type packetData struct {
-	Pid     uint16  `json:",omitempty"`
-	Program uint16  `json:",omitempty"`
-	Pcr     float64 `json:",omitempty"`
-	Pts     float64 `json:",omitempty"`
+	Pid                uint16  `json:",omitempty"`
+	Program            uint16  `json:",omitempty"`
+	Pcr                float64 `json:",omitempty"`
+	Pts                float64 `json:",omitempty"`
+	Dts                float64 `json:",omitempty"`
+	Timeline           float64 `json:",omitempty"` // Pts unwrapped, counting up past the 33 bit rollover
+	PcrTimeline        float64 `json:",omitempty"` // Pcr unwrapped, counting up past the 33 bit rollover
+	SpliceTime         float64 `json:",omitempty"` // splice_time + pts_adjustment, wrapped, or Pts for immediate splices
+	Preroll            float64 `json:",omitempty"` // seconds from Pts, or Pcr without Pts, to SpliceTime
+	BreakEnd           float64 `json:",omitempty"` // SpliceTime + break or segmentation duration, wrapped
+	Immediate          bool    `json:",omitempty"` // splice immediate, or a Time Signal without a time
+	Late               bool    `json:",omitempty"` // SpliceTime had passed when the Cue arrived
+	CueStreamTypeError bool    `json:",omitempty"` // the command isn't allowed by the PID's cue_stream_type
+	ServiceName        string  `json:",omitempty"` // from the SDT or VCT
+	ServiceProvider    string  `json:",omitempty"` // from the SDT
+	CaptureTime        float64 `json:",omitempty"` // seconds since the epoch, from pcap captures
+	ArrivalTime        float64 `json:",omitempty"` // seconds, from the M2TS arrival timestamp, wraps every ~39.7 seconds
 }
 
-// pktSz is the size of an MPEG-TS packet in bytes.
-const pktSz = 188
+// MPEG-TS packet sizes in bytes
+const (
+	pktSz  = 188 // MPEG-TS
+	m2tsSz = 192 // M2TS/BDAV, a 4 byte arrival timestamp then a 188 byte packet
+	rsSz   = 204 // a 188 byte packet then 16 bytes of Reed-Solomon parity
+)
+
+// pktSizes are the packet sizes tried when finding sync.
+var pktSizes = []int{pktSz, m2tsSz, rsSz}
+
+// syncByte starts every MPEG-TS packet.
+const syncByte = 0x47
+
+// syncConfirm is how many sync bytes in a row are needed to find sync.
+const syncConfirm = 3
 
 // bufSz is the size of a read when parsing files.
 const bufSz = 32768 * pktSz
@@ -28,96 +55,518 @@ const mcastPrefix = "udp://@"
 
 // Stream for parsing MPEGTS for SCTE-35
 type Stream struct {
-	Cues     []*Cue
-	Pids     *Pids
-	Pid2Prgm map[uint16]uint16 // pid to program map
-	Pid2Type map[uint16]uint8  // pid to stream type map
-	Programs []uint16
-	Prgm2Pcr map[uint16]uint64 // program to pcr map
-	Prgm2Pts map[uint16]uint64 // program to pts map
-	last     map[uint16][]byte // last compares current packet payload to last packet payload by pid
-	partial  map[uint16][]byte // partial manages tables spread across multiple packets by pid
-	Quiet    bool              // Don't call Cue.Show() when a Cue is found.
+	Cues           []*Cue
+	Pids           *Pids
+	Pid2Prgm       map[uint16]uint16          // pid to program map
+	Pid2Type       map[uint16]uint8           // pid to stream type map
+	Pid2Dscptrs    map[uint16][]PmtDescriptor // pid to PMT elementary stream descriptors map
+	Prgm2Dscptrs   map[uint16][]PmtDescriptor // program to PMT program descriptors map
+	Programs       []uint16
+	Prgm2Service   map[uint16]*Service  // program to SDT or VCT service name map
+	Prgm2Pcr       map[uint16]uint64    // program to pcr map
+	Prgm2PcrLine   map[uint16]*Timeline // program to unwrapped pcr map
+	Prgm2Pts       map[uint16]uint64    // program to pts map
+	Prgm2Line      map[uint16]*Timeline // program to unwrapped pts map
+	Pid2Pts        map[uint16]uint64    // pid to pts map
+	Pid2Dts        map[uint16]uint64    // pid to dts map
+	PtsPid         uint16               // PtsPid is the PID for Cue.PacketData.Pts, zero for the video PID.
+	CueStreamCheck int                  // CueStreamCheck is CueStreamIgnore, CueStreamFlag or CueStreamReject, for commands not allowed by cue_stream_type.
+	Pid2Stats      map[uint16]*PidStats // pid to packet and transport error counts
+	last           map[uint16][]byte    // last compares current packet payload to last packet payload by pid
+	partial        partials             // partial manages tables spread across multiple packets by pid
+	Quiet          bool                 // Don't call Cue.Show() when a Cue is found.
+	ReadTimeout    time.Duration        // ReadTimeout limits each read when decoding live sources, zero for no limit.
+	RtpDepth       int                  // RtpDepth is how many RTP packets are held for reordering and FEC.
+	NoRtp          bool                 // NoRtp parses datagrams as MPEGTS without removing RTP headers.
+	PacketSize     int                  // PacketSize is 188, 192 (M2TS) or 204, zero to detect it.
+	rtp            *rtpReceiver         // rtp tracks RTP sequence numbers
+	captureTime    float64              // captureTime of the current pcap frame
+	carry          []byte               // carry is a partial packet from the last chunk
+	final          bool                 // final is true while parsing the carry at the end of the stream
+	synced         bool                 // synced is true while packets are aligned
+	unit           int                  // unit is the packet size found by findSync
+	arrival        float64              // arrival is the M2TS arrival time of the current packet
+	handler        func(*Cue)           // handler is called with each Cue as it is found
+	cueChan        chan *Cue            // cueChan receives each Cue as it is found
+	tsHandler      func(*TsEvent)       // tsHandler is called with each transport error
+	ctx            context.Context      // ctx is the context being decoded with
+	deadlineMu     sync.Mutex           // deadlineMu keeps setDeadline from undoing stopOnDone
+	prgm2Vid       map[uint16]uint16    // program to first video pid map
+	tsid           uint16               // tsid is the transport_stream_id from the PAT
+	patSeen        bool                 // patSeen is true once a PAT has been parsed
+}
+
+/*
+Handle registers fn to be called with each Cue as soon as it is found.
+
+Cues passed to fn are not collected in Stream.Cues
+or returned by the Decode methods.
+*/
+func (stream *Stream) Handle(fn func(*Cue)) {
+	stream.handler = fn
+}
+
+/*
+CueChan returns a channel that receives each Cue as soon as it is found.
+size sets the channel buffer.
+
+Cues sent on the channel are not collected in Stream.Cues
+or returned by the Decode methods.
+The channel is closed when Decode, DecodeReader or DecodeMulticast return.
+While the context passed to a Context method is done,
+Cues the channel can't take are dropped instead of blocking.
+*/
+func (stream *Stream) CueChan(size int) <-chan *Cue {
+	stream.cueChan = make(chan *Cue, size)
+	return stream.cueChan
+}
+
+// found passes a Cue to the handler and channel, or collects it in Stream.Cues.
+func (stream *Stream) found(cue *Cue) {
+	if !stream.Quiet {
+		cue.Show()
+	}
+	if stream.handler != nil {
+		stream.handler(cue)
+	}
+	if stream.cueChan != nil {
+		select {
+		case stream.cueChan <- cue:
+		case <-stream.done():
+		}
+	}
+	if stream.handler == nil && stream.cueChan == nil {
+		stream.Cues = append(stream.Cues, cue)
+	}
+}
+
+// done returns the Done channel of the context being decoded with, nil blocks forever.
+func (stream *Stream) done() <-chan struct{} {
+	if stream.ctx == nil {
+		return nil
+	}
+	return stream.ctx.Done()
+}
+
+// closeChan closes the channel returned by CueChan and drops the context when decoding ends.
+func (stream *Stream) closeChan() {
+	stream.ctx = nil
+	if stream.cueChan != nil {
+		close(stream.cueChan)
+		stream.cueChan = nil
+	}
 }
 
 // mkMaps Make Stream Maps
 func (stream *Stream) mkMaps() {
 	stream.Pid2Prgm = make(map[uint16]uint16)
 	stream.Pid2Type = make(map[uint16]uint8)
+	stream.Pid2Dscptrs = make(map[uint16][]PmtDescriptor)
+	stream.Prgm2Dscptrs = make(map[uint16][]PmtDescriptor)
+	stream.Prgm2Service = make(map[uint16]*Service)
 	stream.Prgm2Pcr = make(map[uint16]uint64)
+	stream.Prgm2PcrLine = make(map[uint16]*Timeline)
 	stream.Prgm2Pts = make(map[uint16]uint64)
+	stream.Prgm2Line = make(map[uint16]*Timeline)
+	stream.Pid2Pts = make(map[uint16]uint64)
+	stream.Pid2Dts = make(map[uint16]uint64)
+	stream.prgm2Vid = make(map[uint16]uint16)
+	stream.Pid2Stats = make(map[uint16]*PidStats)
 	stream.last = make(map[uint16][]byte)
-	stream.partial = make(map[uint16][]byte)
+	stream.partial = make(partials)
+	stream.rtp = nil
+	stream.captureTime = 0
+	stream.carry = nil
+	stream.final = false
+	stream.synced = false
+	stream.unit = 0
+	stream.arrival = 0
+	stream.tsid = 0
+	stream.patSeen = false
 }
 
 // Decode SCTE-35 Cues from an io.Reader interface
 func (stream *Stream) DecodeReader(rdr io.Reader) []*Cue {
+	cues, err := stream.DecodeReaderContext(context.Background(), rdr)
+	chk(err)
+	return cues
+}
+
+/*
+DecodeReaderContext decodes SCTE-35 Cues from rdr
+until EOF, a read error, or ctx is done.
+
+If rdr has a SetReadDeadline method, like net.Conn and *os.File,
+a blocked read is interrupted when ctx is done
+and Stream.ReadTimeout is applied to each read.
+
+The error is nil at the end of rdr, or ctx.Err() when ctx is done.
+*/
+func (stream *Stream) DecodeReaderContext(ctx context.Context, rdr io.Reader) ([]*Cue, error) {
 	stream.Pids = &Pids{}
 	stream.mkMaps()
+	stream.ctx = ctx
+	defer stream.closeChan()
+	dl, _ := rdr.(deadliner)
+	stop := stream.stopOnDone(ctx, dl)
+	defer stop()
+	decode := stream.DecodeBytes
+	if _, ok := rdr.(net.PacketConn); ok {
+		decode = stream.DecodeDatagram
+	}
 	var cues []*Cue
 	buffer := make([]byte, bufSz)
 	for {
-		_, err := rdr.Read(buffer)
+		stream.setDeadline(dl)
+		n, err := rdr.Read(buffer)
+		if n > 0 {
+			cues = append(cues, decode(buffer[:n])...)
+		}
+		if ctx.Err() != nil {
+			return cues, ctx.Err()
+		}
+		if err == io.EOF {
+			return append(cues, stream.flush()...), nil
+		}
 		if err != nil {
-			break
+			return cues, err
 		}
-		cues = append(cues, stream.DecodeBytes(buffer)...)
 	}
-	return cues
 }
 
-// Decode fname (a file name) for SCTE-35
+// Decode fname (a file name, - for stdin, or url) for SCTE-35
 func (stream *Stream) Decode(fname string) []*Cue {
-	var cues []*Cue
-	if strings.HasPrefix(fname, mcastPrefix) {
-		cues = stream.DecodeMulticast(fname)
-	} else {
-		file, err := os.Open(fname)
-		chk(err)
-		defer file.Close()
-		cues = stream.DecodeReader(file)
-	}
+	cues, err := stream.DecodeContext(context.Background(), fname)
+	chk(err)
 	return cues
 }
 
+/*
+DecodeContext decodes source for SCTE-35
+until the end of the source, an error, or ctx is done.
+
+source is a file name, - for stdin, or a url
+with a scheme added by RegisterSource.
+*/
+func (stream *Stream) DecodeContext(ctx context.Context, source string) ([]*Cue, error) {
+	if isMcast(source) {
+		return stream.DecodeMulticastContext(ctx, source)
+	}
+	if source == "-" {
+		return stream.DecodeReaderContext(ctx, os.Stdin)
+	}
+	if open, ok := sourceFunc(source); ok {
+		rdr, err := open(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		defer rdr.Close()
+		return stream.DecodeReaderContext(ctx, rdr)
+	}
+	file, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return stream.DecodeReaderContext(ctx, file)
+}
+
 /*
 Decode Multicast
 Notes:
   - multicast urls start with udp://@
-  - datagram size should be 1316
+    or udp://source@ for source specific multicast
+  - url parameters iface, pkt and rcvbuf set the interface,
+    datagram size and socket receive buffer,
+    udp://@235.35.3.5:3535?iface=eth1&pkt=1316
+  - RTP is detected and removed, fec=1 joins SMPTE 2022-1 FEC
+    on port + 2 and port + 4, depth sets Stream.RtpDepth
+  - use Stream.Handle or Stream.CueChan to get Cues as they are found,
+    DecodeMulticast only returns on an error.
 */
 func (stream *Stream) DecodeMulticast(fname string) []*Cue {
+	cues, err := stream.DecodeMulticastContext(context.Background(), fname)
+	chk(err)
+	return cues
+}
+
+/*
+DecodeMulticastContext decodes SCTE-35 from a multicast url
+until ctx is done or an error occurs.
+
+Errors binding the socket or reading are returned,
+a read timeout set by Stream.ReadTimeout returns os.ErrDeadlineExceeded.
+The socket is closed before returning.
+*/
+func (stream *Stream) DecodeMulticastContext(ctx context.Context, fname string) ([]*Cue, error) {
 	stream.Pids = &Pids{}
 	stream.mkMaps()
+	stream.ctx = ctx
+	defer stream.closeChan()
+	opts, err := parseMcast(fname)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listenMcast(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	stop := stream.stopOnDone(ctx, l)
+	defer stop()
+	if opts.depth > 0 {
+		stream.RtpDepth = opts.depth
+	}
+	if opts.fec {
+		leave, err := stream.listenFec(opts)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+	}
 	var cues []*Cue
-	dgram := 1316
-	straddr := strings.Replace(fname, mcastPrefix, "", -1)
-	addr, _ := net.ResolveUDPAddr("udp", straddr)
-	l, _ := net.ListenMulticastUDP("udp", nil, addr)
-	l.SetReadBuffer(1316 * 70000)
+	buffer := make([]byte, opts.dgram)
 	for {
-		buffer := make([]byte, dgram)
-		l.ReadFromUDP(buffer)
-		cues = append(cues, stream.DecodeBytes(buffer)...)
+		stream.setDeadline(l)
+		n, _, err := l.ReadFromUDP(buffer)
+		if ctx.Err() != nil {
+			return append(cues, stream.flush()...), ctx.Err()
+		}
+		if err != nil {
+			return append(cues, stream.flush()...), err
+		}
+		cues = append(cues, stream.DecodeDatagram(buffer[:n])...)
 	}
-	return cues
 }
 
+// deadliner is implemented by readers that support read deadlines.
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+/*
+setDeadline applies Stream.ReadTimeout to the next read,
+unless the context is done and stopOnDone has set the deadline to now.
+*/
+func (stream *Stream) setDeadline(dl deadliner) {
+	if dl == nil || stream.ReadTimeout <= 0 {
+		return
+	}
+	stream.deadlineMu.Lock()
+	defer stream.deadlineMu.Unlock()
+	if stream.ctx != nil && stream.ctx.Err() != nil {
+		return
+	}
+	dl.SetReadDeadline(time.Now().Add(stream.ReadTimeout))
+}
+
+/*
+stopOnDone interrupts a blocked read on dl when ctx is done.
+Call the returned func to stop watching ctx.
+*/
+func (stream *Stream) stopOnDone(ctx context.Context, dl deadliner) func() {
+	if dl == nil || ctx.Done() == nil {
+		return func() {}
+	}
+	quit := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.deadlineMu.Lock()
+			dl.SetReadDeadline(time.Now())
+			stream.deadlineMu.Unlock()
+		case <-quit:
+		}
+	}()
+	return func() { close(quit) }
+}
 
-// DecodeBytes Parses a chunk of mpegts bytes for SCTE-35
+/*
+DecodeBytes Parses a chunk of mpegts bytes for SCTE-35
+
+Chunks don't need to start or end on a packet boundary,
+a trailing partial packet is kept and joined to the next chunk.
+Sync is found by looking for syncConfirm sync bytes a packet apart
+and found again the same way when it is lost.
+
+Input too short to confirm sync, like a single packet,
+is parsed if it's whole packets starting with a sync byte.
+
+188, 192 (M2TS/BDAV) and 204 byte packets are detected,
+set Stream.PacketSize to use only one.
+For 192 byte packets, the arrival timestamp is in Cue.PacketData.ArrivalTime.
+*/
 func (stream *Stream) DecodeBytes(bites []byte) []*Cue {
-	for i := 1; i <= (len(bites) / pktSz); i++ {
-		end := i * pktSz
-		start := end - pktSz
-		p := bites[start:end]
-		pkt := &p
-		stream.parse(*pkt)
+	stream.split(bites, stream.parseUnit, nil)
+	cues := stream.Cues
+	stream.Cues = nil
+	return cues
+}
+
+// parseUnit parses pkt, the 188 byte packet in a 188, 192 or 204 byte unit.
+func (stream *Stream) parseUnit(unit []byte, pkt []byte) {
+	if off := syncOffset(stream.unit); off > 0 {
+		stream.arrival = arrivalTime(unit[:off])
+	}
+	stream.parse(pkt)
+}
+
+/*
+split finds packets in bites, after any carry from the last call,
+and calls fn with each packet unit and the 188 byte packet in it.
+skip, when it's not nil, is called with bytes that are out of sync.
+A trailing partial packet is carried over to the next call,
+at the end of the stream it's passed to fn, if it has a whole
+188 byte packet, or to skip.
+*/
+func (stream *Stream) split(bites []byte, fn func(unit []byte, pkt []byte), skip func([]byte)) {
+	if len(stream.carry) > 0 {
+		bites = append(stream.carry, bites...)
+		stream.carry = nil
+	}
+	idx := 0
+	for {
+		if !stream.synced {
+			start := idx
+			idx = stream.findSync(bites, idx)
+			if skip != nil && idx > start {
+				skip(bites[start:idx])
+			}
+			if !stream.synced {
+				break
+			}
+		}
+		off := syncOffset(stream.unit)
+		end := idx + stream.unit
+		if end > len(bites) {
+			if !stream.final || idx+off+pktSz > len(bites) {
+				break
+			}
+			end = len(bites)
+		}
+		if bites[idx+off] != syncByte {
+			stream.synced = false
+			continue
+		}
+		fn(bites[idx:end], bites[idx+off:idx+off+pktSz])
+		idx = end
 	}
+	if idx == len(bites) {
+		return
+	}
+	if stream.final {
+		if skip != nil {
+			skip(bites[idx:])
+		}
+		return
+	}
+	// copy, bites may be in a reused read buffer
+	stream.carry = append([]byte(nil), bites[idx:]...)
+}
+
+/*
+findSync looks for syncConfirm sync bytes in a row, starting at idx,
+trying each packet size in pktSizes, or just Stream.PacketSize when set.
+It returns the index of the first packet and sets Stream.synced and Stream.unit,
+or the index to carry over to the next chunk when there isn't enough data.
+
+When there isn't enough data to confirm sync, the sync bytes there are
+will do at the end of the stream, or when the input is whole packets
+with a sync byte at the start.
+*/
+func (stream *Stream) findSync(bites []byte, idx int) int {
+	sizes := pktSizes
+	if stream.PacketSize > 0 {
+		sizes = []int{stream.PacketSize}
+	}
+	for ; idx < len(bites); idx++ {
+		for _, sz := range sizes {
+			first := idx + syncOffset(sz)
+			if first+(syncConfirm-1)*sz < len(bites) {
+				if syncEvery(bites, first, sz) {
+					return stream.syncAt(idx, sz)
+				}
+				continue
+			}
+			if (stream.final || idx == 0 && len(bites)%sz == 0) && syncSome(bites, first, sz) {
+				return stream.syncAt(idx, sz)
+			}
+			if !stream.final {
+				return idx
+			}
+		}
+	}
+	return idx
+}
+
+// syncAt sets sync at idx with sz byte packets and returns idx.
+func (stream *Stream) syncAt(idx int, sz int) int {
+	stream.synced = true
+	stream.unit = sz
+	return idx
+}
+
+// syncSome is syncEvery for the packets bites has room for, at least one whole packet.
+func syncSome(bites []byte, idx int, sz int) bool {
+	if idx+pktSz > len(bites) {
+		return false
+	}
+	for i := 0; i < syncConfirm && idx+i*sz < len(bites); i++ {
+		if bites[idx+i*sz] != syncByte {
+			return false
+		}
+	}
+	return true
+}
+
+// flushCarry parses the partial data left at the end of the stream.
+func (stream *Stream) flushCarry() []*Cue {
+	stream.splitCarry(stream.parseUnit, nil)
 	cues := stream.Cues
-	stream.Cues = stream.Cues[:0]
+	stream.Cues = nil
 	return cues
 }
 
+// splitCarry is split for the partial data left at the end of the stream.
+func (stream *Stream) splitCarry(fn func(unit []byte, pkt []byte), skip func([]byte)) {
+	if len(stream.carry) == 0 {
+		return
+	}
+	stream.final = true
+	stream.split(nil, fn, skip)
+	stream.final = false
+}
+
+// flush parses RTP payloads held for reordering, then any partial data left.
+func (stream *Stream) flush() []*Cue {
+	cues := stream.flushRtp()
+	return append(cues, stream.flushCarry()...)
+}
+
+// syncEvery returns true if there are syncConfirm sync bytes sz apart, starting at idx.
+func syncEvery(bites []byte, idx int, sz int) bool {
+	for i := 0; i < syncConfirm; i++ {
+		if bites[idx+i*sz] != syncByte {
+			return false
+		}
+	}
+	return true
+}
+
+// syncOffset returns where the sync byte is in a packet of sz bytes.
+func syncOffset(sz int) int {
+	if sz == m2tsSz {
+		return m2tsSz - pktSz
+	}
+	return 0
+}
+
+// arrivalTime returns the 30 bit, 27MHz M2TS arrival timestamp in seconds.
+func arrivalTime(head []byte) float64 {
+	ats := uint64(head[0]&0x3f)<<24 | uint64(head[1])<<16 | uint64(head[2])<<8 | uint64(head[3])
+	return float64(uint64(float64(ats)/27000.0)) / 1000
+}
+
 // afcFlag returns true if AFC flag is set
 func (stream *Stream) afcFlag(pkt []byte) bool {
 	return (pkt[3]&0x20 == 0x20)
@@ -128,35 +577,12 @@ func (stream *Stream) pcrFlag(pkt []byte) bool {
 	return (pkt[5]&0x10 == 0x10)
 }
 
-// ptsFlag returns true if PTS flag is set
-func (stream *Stream) ptsFlag(pay []byte) bool {
-	return (pay[7]&0x80 == 0x80)
-}
-
 // parsePusi returns true if PUSI flag is set
 func (stream *Stream) parsePusi(pkt []byte) bool {
 	return (pkt[1]&0x40 == 0x40)
 
 }
 
-// parsePts parses a packet for PTS
-func (stream *Stream) parsePts(pay []byte, pid uint16) {
-	if len(pay) > 13 {
-		if stream.ptsFlag(pay) {
-			prgm, ok := stream.Pid2Prgm[pid]
-			if ok {
-				pts := uint64(pay[9]&14) << 29
-				pts |= uint64(pay[10]) << 22
-				pts |= (uint64(pay[11]) >> 1) << 15
-				pts |= uint64(pay[12]) << 7
-				pts |= uint64(pay[13]) >> 1
-				stream.Prgm2Pts[prgm] = pts
-                fmt.Println(pts)
-			}
-		}
-	}
-}
-
 // parsePcr parses a packet for PCR
 func (stream *Stream) parsePcr(pkt []byte, pid uint16) {
 	if stream.afcFlag(pkt) {
@@ -169,6 +595,7 @@ func (stream *Stream) parsePcr(pkt []byte, pid uint16) {
 				pcr |= (uint64(pkt[9]) << 1)
 				pcr |= uint64(pkt[10]) >> 7
 				stream.Prgm2Pcr[prgm] = pcr
+				timeline(stream.Prgm2PcrLine, prgm).Unwrap(Pts(pcr))
 			}
 		}
 	}
@@ -187,164 +614,232 @@ func (stream *Stream) parsePayload(pkt []byte) []byte {
 	return pkt[head:]
 }
 
-// chkPartial appends the current packet payload to partial table by pid.
-func (stream *Stream) chkPartial(pay []byte, pid uint16, sep []byte) []byte {
-	val, ok := stream.partial[pid]
-	if ok {
-		pay = append(val, pay...)
+// pesStart starts a PES packet carrying SCTE-35.
+var pesStart = []byte("\x00\x00\x01\xfc")
+
+// partials holds sections spread across multiple packets by pid.
+type partials map[uint16][]byte
+
+/*
+sections returns the complete PSI sections in a packet payload.
+
+When PUSI is set, the pointer_field gives the bytes that finish
+the section in progress, a new section starts after them.
+Sections are split by section_length, 0xff stuffing ends the payload.
+A section that doesn't end in the packet is kept by pid.
+*/
+func (parts partials) sections(pay []byte, pid uint16, pusi bool) [][]byte {
+	if !pusi {
+		return parts.moreSections(pay, pid)
+	}
+	if bytes.HasPrefix(pay, pesStart) {
+		return parts.newSections(pesPayload(pay), pid)
+	}
+	if len(pay) == 0 {
+		return nil
+	}
+	ptr := int(pay[0]) + 1
+	if ptr > len(pay) {
+		delete(parts, pid)
+		return nil
 	}
-	return splitByIdx(pay, sep)
+	secs := parts.moreSections(pay[1:ptr], pid)
+	return append(secs, parts.newSections(pay[ptr:], pid)...)
 }
 
-// sameAsLast compares the current packet to the last packet by pid.
-func (stream *Stream) sameAsLast(pay []byte, pid uint16) bool {
-	val, ok := stream.last[pid]
-	if ok {
-		if bytes.Compare(pay, val) == 0 {
-			return true
+// moreSections adds pay to the section in progress for pid, if there is one.
+func (parts partials) moreSections(pay []byte, pid uint16) [][]byte {
+	val, ok := parts[pid]
+	if !ok {
+		return nil
+	}
+	return parts.newSections(append(val, pay...), pid)
+}
+
+// newSections splits pay into sections, starting at pay[0].
+func (parts partials) newSections(pay []byte, pid uint16) [][]byte {
+	delete(parts, pid)
+	var secs [][]byte
+	for len(pay) > 0 && pay[0] != 0xff {
+		if len(pay) < 3 {
+			break
+		}
+		seclen := 3 + int(parseLen(pay[1], pay[2]))
+		if seclen > len(pay) {
+			break
 		}
+		secs = append(secs, pay[:seclen])
+		pay = pay[seclen:]
 	}
-	stream.last[pid] = pay
-	return false
+	if len(pay) > 0 && pay[0] != 0xff {
+		// copy, pay may be in a reused read buffer
+		parts[pid] = append([]byte(nil), pay...)
+	}
+	return secs
 }
 
-// sectionDone aggregates partial tables by pid until the section is complete.
-func (stream *Stream) sectionDone(pay []byte, pid uint16, seclen uint16) bool {
-	if seclen+3 > uint16(len(pay)) {
-		stream.partial[pid] = pay
-		return false
+// pesPayload returns the SCTE-35 section in a PES packet.
+func pesPayload(pay []byte) []byte {
+	if len(pay) < 9 {
+		return nil
 	}
-	delete(stream.partial, pid)
-	return true
+	head := 9 + int(pay[8])
+	if head > len(pay) {
+		return nil
+	}
+	pay = pay[head:]
+	idx := bytes.IndexByte(pay, 0xfc)
+	if idx == -1 {
+		return nil
+	}
+	return pay[idx:]
 }
 
-func (stream *Stream) stripScte35Pes(pay []byte, pid uint16) *[]byte {
-	scte35PesStart := []byte("\x00\x00\x01\xfc")
-	if bytes.Contains(pay, scte35PesStart) {
-		_, pay, _ = bytes.Cut(pay, scte35PesStart)
+// sameAsLast compares the current section to the last section by pid.
+func (stream *Stream) sameAsLast(sec []byte, pid uint16) bool {
+	val, ok := stream.last[pid]
+	if ok {
+		if bytes.Compare(sec, val) == 0 {
+			return true
+		}
 	}
-	pay = splitByIdx(pay, []byte("\xfc"))
-	return &pay
+	// copy, sec may be in a reused read buffer
+	stream.last[pid] = append([]byte(nil), sec...)
+	return false
+}
+
+// crcOk returns true if the CRC_32 at the end of sec is correct.
+func crcOk(sec []byte) bool {
+	return crc32(sec) == 0
 }
 
 // parse is the parser method for Stream
 func (stream *Stream) parse(pkt []byte) {
-	p := parsePid(pkt[1], pkt[2])
-	pid := &p
-	pl := stream.parsePayload(pkt)
-	pay := &pl
-	if *pid == 0 {
-		stream.parsePat(*pay, *pid)
+	pid := parsePid(pkt[1], pkt[2])
+	if !stream.checkCc(pkt, pid) {
+		return
+	}
+	pay := stream.parsePayload(pkt)
+	pusi := stream.parsePusi(pkt)
+	if pid == 0 {
+		for _, sec := range stream.partial.sections(pay, pid, pusi) {
+			stream.parsePat(sec, pid)
+		}
+	}
+	if stream.Pids.isPmtPid(pid) {
+		for _, sec := range stream.partial.sections(pay, pid, pusi) {
+			stream.parsePmt(sec, pid)
+		}
+	}
+	if pid == sdtPid {
+		for _, sec := range stream.partial.sections(pay, pid, pusi) {
+			stream.parseSdt(sec, pid)
+		}
+	}
+	if pid == vctPid {
+		for _, sec := range stream.partial.sections(pay, pid, pusi) {
+			stream.parseVct(sec, pid)
+		}
+	}
+	if stream.Pids.isPcrPid(pid) {
+		stream.parsePcr(pkt, pid)
 	}
-	if stream.Pids.isPmtPid(*pid) {
-		stream.parsePmt(*pay, *pid)
+	if pusi {
+		stream.parsePts(pay, pid)
 	}
-	if stream.Pids.isPcrPid(*pid) {
-		stream.parsePcr(pkt, *pid)
-    }
-		if stream.parsePusi(pkt) {
-			stream.parsePts(*pay, *pid)
+	if stream.Pids.isScte35Pid(pid) {
+		for _, sec := range stream.partial.sections(pay, pid, pusi) {
+			stream.parseScte35(sec, pid)
 		}
-	
-	if stream.Pids.isScte35Pid(*pid) {
-		pay = stream.stripScte35Pes(*pay, *pid)
-		stream.parseScte35(*pay, *pid)
 	}
 }
 
-// parsePat parses PAT payload
-func (stream *Stream) parsePat(pay []byte, pid uint16) {
-	if stream.sameAsLast(pay, pid) {
+// parsePat parses a PAT section
+func (stream *Stream) parsePat(sec []byte, pid uint16) {
+	if sec[0] != 0x00 || len(sec) < 12 || stream.sameAsLast(sec, pid) || !crcOk(sec) {
 		return
 	}
-	pay = stream.chkPartial(pay, pid, []byte("\x00\x00"))
-	if len(pay) < 1 {
-		return
-	}
-	seclen := parseLen(pay[2], pay[3])
-	if stream.sectionDone(pay, pid, seclen) {
-		seclen -= 5 // pay bytes 4,5,6,7,8
-		idx := uint16(9)
-		end := idx + seclen - 4 //  4 bytes for crc
-		chunksize := uint16(4)
-		for idx < end {
-			prgm := parsePrgm(pay[idx], pay[idx+1])
-			if prgm > 0 {
-				if !IsIn(stream.Programs, prgm) {
-					stream.Programs = append(stream.Programs, prgm)
-				}
-				pmtpid := parsePid(pay[idx+2], pay[idx+3])
-				stream.Pids.addPmtPid(pmtpid)
+	stream.tsid = parsePrgm(sec[3], sec[4])
+	stream.patSeen = true
+	end := len(sec) - 4 //  4 bytes for crc
+	chunksize := 4
+	for idx := 8; idx+chunksize <= end; idx += chunksize {
+		prgm := parsePrgm(sec[idx], sec[idx+1])
+		if prgm > 0 {
+			if !IsIn(stream.Programs, prgm) {
+				stream.Programs = append(stream.Programs, prgm)
 			}
-			idx += chunksize
+			pmtpid := parsePid(sec[idx+2], sec[idx+3])
+			stream.Pids.addPmtPid(pmtpid)
 		}
 	}
 }
 
-// parsePmt parses PMT payload
-func (stream *Stream) parsePmt(pay []byte, pid uint16) {
-	if stream.sameAsLast(pay, pid) {
+// parsePmt parses a PMT section
+func (stream *Stream) parsePmt(sec []byte, pid uint16) {
+	if sec[0] != 0x02 || len(sec) < 16 || stream.sameAsLast(sec, pid) || !crcOk(sec) {
 		return
 	}
-	pay = stream.chkPartial(pay, pid, []byte("\x02"))
-	if len(pay) < 1 {
+	prgm := parsePrgm(sec[3], sec[4])
+	pcrpid := parsePid(sec[8], sec[9])
+	stream.Pids.addPcrPid(pcrpid)
+	proginfolen := int(parseLen(sec[10], sec[11]))
+	idx := 12 + proginfolen
+	end := len(sec) - 4 //  4 bytes for crc
+	if idx > end {
 		return
 	}
-	secinfolen := parseLen(pay[1], pay[2])
-	if stream.sectionDone(pay, pid, secinfolen) {
-		prgm := parsePrgm(pay[3], pay[4])
-		pcrpid := parsePid(pay[8], pay[9])
-		stream.Pids.addPcrPid(pcrpid)
-		proginfolen := parseLen(pay[10], pay[11])
-		idx := uint16(12)
-		idx += proginfolen
-		silen := secinfolen - 9
-		silen -= proginfolen
-		stream.parseStreams(silen, pay, idx, prgm)
-	}
+	stream.Prgm2Dscptrs[prgm] = parsePmtDescriptors(sec[12:idx])
+	stream.parseStreams(sec, idx, end, prgm)
 }
 
 // parseStreams parses program stream information
-func (stream *Stream) parseStreams(silen uint16, pay []byte, idx uint16, prgm uint16) {
-	chunksize := uint16(5)
-	endidx := (idx + silen) - chunksize
-	for idx < endidx {
-		streamtype := pay[idx]
-		elpid := parsePid(pay[idx+1], pay[idx+2])
-		eilen := parseLen(pay[idx+3], pay[idx+4])
+func (stream *Stream) parseStreams(sec []byte, idx int, end int, prgm uint16) {
+	chunksize := 5
+	for idx+chunksize <= end {
+		streamtype := sec[idx]
+		elpid := parsePid(sec[idx+1], sec[idx+2])
+		eilen := int(parseLen(sec[idx+3], sec[idx+4]))
 		idx += chunksize
+		if idx+eilen > end {
+			eilen = end - idx
+		}
+		dscptrs := parsePmtDescriptors(sec[idx : idx+eilen])
 		idx += eilen
 		stream.Pid2Prgm[elpid] = prgm
 		stream.Pid2Type[elpid] = streamtype
-		stream.vrfyStreamType(elpid, streamtype)
+		stream.Pid2Dscptrs[elpid] = dscptrs
+		if _, ok := stream.prgm2Vid[prgm]; !ok && isVideo(streamtype) {
+			stream.prgm2Vid[prgm] = elpid
+		}
+		stream.vrfyStreamType(elpid, streamtype, dscptrs)
 	}
 }
 
-// vrfyStreamType checks for stream types 6 and 134 and adds them to Stream.Pids.Scte35Pids
-func (stream *Stream) vrfyStreamType(pid uint16, streamtype uint8) {
-	if streamtype == 6 || streamtype == 134 {
+/*
+vrfyStreamType adds SCTE-35 PIDs to Stream.Pids.Scte35Pids,
+stream type 0x86, or 0x06 with a CUEI Registration Descriptor
+or a Cue Identifier Descriptor. PIDs that change to another
+stream type are removed.
+*/
+func (stream *Stream) vrfyStreamType(pid uint16, streamtype uint8, dscptrs []PmtDescriptor) {
+	if isScte35(streamtype, dscptrs) {
 		stream.Pids.addScte35Pid(pid)
+		return
 	}
+	stream.Pids.delScte35Pid(pid)
 }
 
-// parseSCTE35 parses SCTE35 packets
-func (stream *Stream) parseScte35(pay []byte, pid uint16) {
-	pay = stream.chkPartial(pay, pid, []byte("\xfc"))
-	if len(pay) == 0 {
-	//	stream.Pids.delScte35Pid(pid)
+// parseSCTE35 parses a SCTE35 section, sections with a bad CRC are dropped
+func (stream *Stream) parseScte35(sec []byte, pid uint16) {
+	if sec[0] != 0xfc || !crcOk(sec) {
 		return
 	}
-	seclen := parseLen(pay[1], pay[2])
-	if stream.sectionDone(pay, pid, seclen) {
-		cue := stream.mkCue(pid)
-		if cue.Decode(pay) {
-			stream.Cues = append(stream.Cues, cue)
-			if !stream.Quiet {
-				cue.Show()
-			}
-		} else {
-			//stream.Pids.delScte35Pid(pid)
-			return
+	cue := stream.mkCue(pid)
+	if cue.Decode(sec) {
+		cue.spliceTiming()
+		if stream.checkCueStream(cue, pid) {
+			stream.found(cue)
 		}
 	}
 }
@@ -359,9 +854,89 @@ func (stream *Stream) mkCue(pid uint16) *Cue {
 	cue.PacketData.Program = *prgm
 	cue.PacketData.Pcr = mk90k(stream.Prgm2Pcr[*prgm])
 	cue.PacketData.Pts = mk90k(stream.Prgm2Pts[*prgm])
+	if line, ok := stream.Prgm2Line[*prgm]; ok {
+		cue.PacketData.Timeline = line.Now()
+	}
+	if line, ok := stream.Prgm2PcrLine[*prgm]; ok {
+		cue.PacketData.PcrTimeline = line.Now()
+	}
+	if ref, ok := stream.refPid(*prgm); ok {
+		cue.PacketData.Pts = mk90k(stream.Pid2Pts[ref])
+		cue.PacketData.Dts = mk90k(stream.Pid2Dts[ref])
+	}
+	if svc, ok := stream.Prgm2Service[*prgm]; ok {
+		cue.PacketData.ServiceName = svc.Name
+		cue.PacketData.ServiceProvider = svc.Provider
+	}
+	cue.PacketData.CaptureTime = stream.captureTime
+	cue.PacketData.ArrivalTime = stream.arrival
 	return cue
 }
 
+/*
+spliceTiming sets the splice time, preroll and break end
+in Cue.PacketData for Splice Inserts and Time Signals.
+Cancels and component splices have no splice time.
+*/
+func (cue *Cue) spliceTiming() {
+	pd := cue.PacketData
+	if pd == nil || cue.Command == nil {
+		return
+	}
+	cmd := cue.Command
+	switch cmd.CommandType {
+	case 5:
+		if cmd.SpliceEventCancelIndicator {
+			return
+		}
+		pd.Immediate = cmd.SpliceImmediateFlag
+		if !cmd.ProgramSpliceFlag {
+			// component splices have a time per component
+			return
+		}
+	case 6:
+		pd.Immediate = !cmd.TimeSpecifiedFlag
+	default:
+		return
+	}
+	now := pd.Pts
+	if now == 0 {
+		now = pd.Pcr
+	}
+	splice := NewPts(cue.splicePts())
+	if pd.Immediate {
+		splice = NewPts(now)
+	}
+	pd.SpliceTime = splice.Seconds()
+	if now > 0 && !pd.Immediate {
+		pd.Preroll = splice.Sub(NewPts(now))
+		pd.Late = pd.Preroll < 0
+	}
+	if dur := cue.breakDuration(); dur > 0 {
+		pd.BreakEnd = splice.Add(dur).Seconds()
+	}
+}
+
+/*
+breakDuration returns the break duration of a CUE-OUT Splice Insert,
+or the first Segmentation Descriptor duration of a Time Signal.
+*/
+func (cue *Cue) breakDuration() float64 {
+	cmd := cue.Command
+	if cmd.CommandType == 5 {
+		if cmd.OutOfNetworkIndicator && cmd.DurationFlag {
+			return cmd.BreakDuration
+		}
+		return 0
+	}
+	for _, dscptr := range cue.Descriptors {
+		if dscptr.Tag == 2 && dscptr.SegmentationDurationFlag && !dscptr.SegmentationEventCancelIndicator {
+			return dscptr.SegmentationDuration
+		}
+	}
+	return 0
+}
+
 // initialize and return a *Stream
 func NewStream() *Stream {
 	stream := &Stream{}
@@ -0,0 +1,417 @@
+package cuei
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCue is a Time Signal at PTS 11111111 ticks, splice time 123.456789, PtsAdjustment 0.
+const testCue = "/DAWAAAAAAAAAP/wBQb+AKmKxwAACzuu2Q=="
+
+// testPmtPid, testVidPid and testScte35Pid are the PIDs in testTs.
+const (
+	testPmtPid    = 0x30
+	testVidPid    = 0x100
+	testScte35Pid = 0x102
+)
+
+/*
+testTs returns 188 byte MPEGTS with a PAT, a PMT for program 1,
+H.264 video on testVidPid and SCTE-35 on testScte35Pid,
+then each section in secs on testScte35Pid.
+*/
+func testTs(secs ...[]byte) []byte {
+	pat := finishSection([]byte{0x00, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0, 0x00, 0x01, 0xe0, testPmtPid})
+	pmt := finishSection([]byte{0x02, 0xb0, 0, 0x00, 0x01, 0xc1, 0, 0, 0xe1, 0x00, 0xf0, 0x00,
+		0x1b, 0xe1, 0x00, 0xf0, 0x00, 0x86, 0xe1, 0x02, 0xf0, 0x00})
+	ts, _ := packetize(pat, 0, 0)
+	pkts, _ := packetize(pmt, testPmtPid, 0)
+	ts = append(ts, pkts...)
+	cc := uint8(0)
+	for _, sec := range secs {
+		pkts, cc = packetize(sec, testScte35Pid, cc)
+		ts = append(ts, pkts...)
+	}
+	return ts
+}
+
+// testCues returns n copies of testCue as sections.
+func testCues(n int) [][]byte {
+	var secs [][]byte
+	for i := 0; i < n; i++ {
+		secs = append(secs, decB64(testCue))
+	}
+	return secs
+}
+
+func TestStreamCues(t *testing.T) {
+	stream := NewStream()
+	stream.Quiet = true
+	cues := stream.DecodeReader(bytes.NewReader(testTs(testCues(2)...)))
+	if len(cues) != 2 {
+		t.Fatalf("got %d Cues, want 2", len(cues))
+	}
+	if cues[0].PacketData.Pid != testScte35Pid || cues[0].PacketData.Program != 1 {
+		t.Fatalf("PacketData is %v", mkJson(cues[0].PacketData))
+	}
+}
+
+func TestStreamHandle(t *testing.T) {
+	stream := NewStream()
+	stream.Quiet = true
+	var handled []*Cue
+	stream.Handle(func(cue *Cue) { handled = append(handled, cue) })
+	cues := stream.DecodeReader(bytes.NewReader(testTs(testCues(3)...)))
+	if len(handled) != 3 || len(cues) != 0 {
+		t.Fatalf("handled %d Cues and returned %d, want 3 and 0", len(handled), len(cues))
+	}
+	if handled[0].Encode2B64() != testCue {
+		t.Fatalf("handled %v", handled[0].Encode2B64())
+	}
+}
+
+func TestStreamCueChan(t *testing.T) {
+	stream := NewStream()
+	stream.Quiet = true
+	ch := stream.CueChan(0)
+	go stream.DecodeReader(bytes.NewReader(testTs(testCues(3)...)))
+	n := 0
+	for cue := range ch {
+		if cue.Encode2B64() != testCue {
+			t.Fatalf("got %v", cue.Encode2B64())
+		}
+		n++
+	}
+	if n != 3 {
+		t.Fatalf("got %d Cues, want 3", n)
+	}
+}
+
+func TestStreamCueChanCancel(t *testing.T) {
+	stream := NewStream()
+	stream.Quiet = true
+	// nobody reads the channel
+	stream.CueChan(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := stream.DecodeReaderContext(ctx, bytes.NewReader(testTs(testCues(3)...)))
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("decoder blocked on the channel after cancel")
+	}
+}
+
+func TestDecodeReaderContextCancel(t *testing.T) {
+	rd, wr := net.Pipe()
+	defer wr.Close()
+	defer rd.Close()
+	stream := NewStream()
+	stream.Quiet = true
+	stream.ReadTimeout = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := stream.DecodeReaderContext(ctx, rd)
+		errc <- err
+	}()
+	// a few reads, each one re-arms the deadline
+	for i := 0; i < 3; i++ {
+		wr.Write(testTs())
+	}
+	cancel()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked read wasn't interrupted by cancel")
+	}
+}
+
+// decodeChunks feeds ts to stream chunk bytes at a time, then flushes.
+func decodeChunks(stream *Stream, ts []byte, chunk int) []*Cue {
+	var cues []*Cue
+	for len(ts) > 0 {
+		n := chunk
+		if n > len(ts) {
+			n = len(ts)
+		}
+		cues = append(cues, stream.DecodeBytes(ts[:n])...)
+		ts = ts[n:]
+	}
+	return append(cues, stream.flush()...)
+}
+
+func TestStreamResync(t *testing.T) {
+	// sync bytes, but never a packet size apart
+	garbage := bytes.Repeat([]byte{0x47, 0xff, 0, 1, 2, 3, 4}, 48)
+	ts := testTs(testCues(5)...)
+	// PAT, PMT and two cues before the garbage
+	mid := 4 * pktSz
+	tests := []struct {
+		name          string
+		before, after []byte
+	}{
+		{"garbage first", nil, ts},
+		{"garbage in the middle", ts[:mid], ts[mid:]},
+	}
+	for _, tt := range tests {
+		for _, sz := range pktSizes {
+			in := append(resize(tt.before, sz), garbage...)
+			in = append(in, resize(tt.after, sz)...)
+			stream := NewStream()
+			stream.Quiet = true
+			if cues := decodeChunks(stream, in, 1000); len(cues) != 5 {
+				t.Errorf("%v, %d byte packets: got %d Cues, want 5", tt.name, sz, len(cues))
+			}
+		}
+	}
+}
+
+func TestStreamShortInput(t *testing.T) {
+	ts := testTs()
+	stream := NewStream()
+	stream.Quiet = true
+	// PAT and PMT, one packet at a time
+	stream.DecodeBytes(ts[:pktSz])
+	stream.DecodeBytes(ts[pktSz:])
+	if !IsIn(stream.Pids.Scte35Pids, testScte35Pid) {
+		t.Fatalf("SCTE-35 pid %#x not found", testScte35Pid)
+	}
+	cue := NewCue()
+	cue.Decode(testCue)
+	pkts, _ := cue.Encode2Packets(testScte35Pid, 0)
+	cues := stream.DecodeBytes(pkts[0])
+	if len(cues) != 1 {
+		t.Fatalf("got %d Cues from one packet, want 1", len(cues))
+	}
+}
+
+func TestStreamFlushCarry(t *testing.T) {
+	ts := testTs()
+	// the cue after a PAT and garbage is too close to the end to confirm sync
+	ts = append(ts, ts[:pktSz]...)
+	ts = append(ts, bytes.Repeat([]byte{0xff}, 50)...)
+	ts = append(ts, testTs(testCues(1)...)[2*pktSz:]...)
+	stream := NewStream()
+	stream.Quiet = true
+	if cues := stream.DecodeReader(bytes.NewReader(ts)); len(cues) != 1 {
+		t.Fatalf("got %d Cues, want 1", len(cues))
+	}
+}
+
+// resize converts 188 byte packets to sz byte packets, 192 byte packets get an arrival time of 1.5 seconds.
+func resize(ts []byte, sz int) []byte {
+	var out []byte
+	for i := 0; i+pktSz <= len(ts); i += pktSz {
+		if sz == m2tsSz {
+			// 1.5 seconds at 27MHz
+			out = append(out, 0x02, 0x69, 0xfb, 0x20)
+		}
+		out = append(out, ts[i:i+pktSz]...)
+		if sz == rsSz {
+			out = append(out, make([]byte, rsSz-pktSz)...)
+		}
+	}
+	return out
+}
+
+func TestStreamPacketSizes(t *testing.T) {
+	for _, sz := range pktSizes {
+		for _, chunk := range []int{7, 100, 1000, 1 << 20} {
+			stream := NewStream()
+			stream.Quiet = true
+			cues := decodeChunks(stream, resize(testTs(testCues(3)...), sz), chunk)
+			if len(cues) != 3 || stream.unit != sz {
+				t.Fatalf("%d byte packets in %d byte chunks: got %d Cues, packet size %d", sz, chunk, len(cues), stream.unit)
+			}
+			arrival := cues[0].PacketData.ArrivalTime
+			if sz == m2tsSz && arrival != 1.5 || sz != m2tsSz && arrival != 0 {
+				t.Fatalf("%d byte packets: ArrivalTime is %v", sz, arrival)
+			}
+		}
+	}
+}
+
+func TestStreamPacketSizeForced(t *testing.T) {
+	m2ts := resize(testTs(testCues(3)...), m2tsSz)
+	tests := []struct {
+		size int
+		cues int
+	}{
+		{0, 3},
+		{m2tsSz, 3},
+		// 192 byte packets never sync as 188 or 204
+		{pktSz, 0},
+		{rsSz, 0},
+	}
+	for _, tt := range tests {
+		stream := NewStream()
+		stream.Quiet = true
+		stream.PacketSize = tt.size
+		cues := decodeChunks(stream, m2ts, 1000)
+		if len(cues) != tt.cues {
+			t.Errorf("PacketSize %d: got %d Cues, want %d", tt.size, len(cues), tt.cues)
+		}
+		if tt.cues > 0 && stream.unit != m2tsSz {
+			t.Errorf("PacketSize %d: packet size is %d", tt.size, stream.unit)
+		}
+	}
+}
+
+// psiPay is a packet payload for partials tests.
+type psiPay struct {
+	pay  []byte
+	pusi bool
+}
+
+// cat joins byte slices.
+func cat(parts ...[]byte) []byte {
+	var out []byte
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+func TestSections(t *testing.T) {
+	// payload bytes that look like table ids and sync bytes
+	secA := finishSection(cat([]byte{0xfc, 0x30, 0}, bytes.Repeat([]byte{0x00, 0x02, 0xfc, 0x47}, 10)))
+	secB := finishSection(cat([]byte{0xfc, 0x30, 0}, bytes.Repeat([]byte{0x00, 0x00, 0xff, 0x02}, 25)))
+	stuff := bytes.Repeat([]byte{0xff}, 20)
+	tests := []struct {
+		name string
+		pays []psiPay
+		want [][]byte
+	}{
+		{"one section", []psiPay{{cat([]byte{0}, secA, stuff), true}}, [][]byte{secA}},
+		{"two sections in a packet", []psiPay{{cat([]byte{0}, secA, secB, stuff), true}}, [][]byte{secA, secB}},
+		{"split", []psiPay{{cat([]byte{0}, secA[:20]), true}, {cat(secA[20:], stuff), false}}, [][]byte{secA}},
+		{"split in three", []psiPay{{cat([]byte{0}, secB[:2]), true}, {secB[2:50], false}, {cat(secB[50:], stuff), false}}, [][]byte{secB}},
+		{"pointer_field ends a section", []psiPay{{cat([]byte{0}, secA[:20]), true}, {cat([]byte{27}, secA[20:], secB), true}}, [][]byte{secA, secB}},
+		{"no start", []psiPay{{cat(secA[20:], stuff), false}}, nil},
+		{"pointer_field past the payload", []psiPay{{cat([]byte{0}, secA[:20]), true}, {[]byte{200, 1, 2}, true}, {secA[20:], false}}, nil},
+		{"start lost", []psiPay{{cat([]byte{27}, secA[20:], secB[:10]), true}, {secB[10:], false}}, [][]byte{secB}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := partials{}
+			var got [][]byte
+			for _, p := range tt.pays {
+				got = append(got, parts.sections(p.pay, testScte35Pid, p.pusi)...)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d sections, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Fatalf("section %d is %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+			if len(parts) != 0 {
+				t.Fatalf("partial section left: %x", parts[testScte35Pid])
+			}
+		})
+	}
+}
+
+func TestBadCrc(t *testing.T) {
+	// PAT, PMT and a cue, then a cue with a bad CRC
+	bad := decB64(testCue)
+	bad[len(bad)-1] ^= 0xff
+	ts := testTs(decB64(testCue), bad)
+	stream := NewStream()
+	stream.Quiet = true
+	if cues := stream.DecodeBytes(ts); len(cues) != 1 {
+		t.Fatalf("got %d Cues, want 1", len(cues))
+	}
+	// corrupt the PAT and PMT
+	for _, idx := range []int{pktSz - 1, 2*pktSz - 1} {
+		ts := testTs(testCues(1)...)
+		for ts[idx] == 0xff {
+			idx--
+		}
+		ts[idx] ^= 0xff
+		stream := NewStream()
+		stream.Quiet = true
+		if cues := stream.DecodeBytes(ts); len(cues) != 0 {
+			t.Fatalf("got %d Cues with a bad CRC at %d, want 0", len(cues), idx)
+		}
+	}
+}
+
+func TestSpliceTiming(t *testing.T) {
+	// Time Signal at 104 with a 60 second segmentation
+	signal := func(timed bool) *Cue {
+		cue := &Cue{InfoSection: &InfoSection{}, Command: &Command{}}
+		cue.Command.CommandType = 6
+		cue.Command.TimeSpecifiedFlag = timed
+		cue.Command.PTS = 104.0
+		var dscptr Descriptor
+		dscptr.Tag = 2
+		dscptr.SegmentationDurationFlag = true
+		dscptr.SegmentationDuration = 60.0
+		cue.Descriptors = []Descriptor{dscptr}
+		return cue
+	}
+	wrapped := insertCue(1, true, NewPts(-1.0).Seconds(), 30.0)
+	wrapped.InfoSection.PtsAdjustment = 3.0
+	immediate := insertCue(1, true, 0, 30.0)
+	immediate.Command.SpliceImmediateFlag = true
+	immediate.Command.TimeSpecifiedFlag = false
+	cancel := insertCue(1, false, 0, 0)
+	cancel.Command.SpliceEventCancelIndicator = true
+	cancel.Command.ProgramSpliceFlag = false
+	cancel.Command.TimeSpecifiedFlag = false
+	component := insertCue(1, true, 0, 30.0)
+	component.Command.ProgramSpliceFlag = false
+	component.Command.TimeSpecifiedFlag = false
+	tests := []struct {
+		name     string
+		cue      *Cue
+		pts, pcr float64
+		want     packetData
+	}{
+		{"insert", insertCue(1, true, 104.0, 30.0), 100.0, 0,
+			packetData{Pts: 100, SpliceTime: 104, Preroll: 4, BreakEnd: 134}},
+		{"cue-in", insertCue(1, false, 104.0, 0), 100.0, 0,
+			packetData{Pts: 100, SpliceTime: 104, Preroll: 4}},
+		{"late", insertCue(1, true, 104.0, 30.0), 110.0, 0,
+			packetData{Pts: 110, SpliceTime: 104, Preroll: -6, Late: true, BreakEnd: 134}},
+		{"pcr without pts", insertCue(1, true, 104.0, 30.0), 0, 99.5,
+			packetData{Pcr: 99.5, SpliceTime: 104, Preroll: 4.5, BreakEnd: 134}},
+		{"pts_adjustment across the wrap", wrapped, 0.5, 0,
+			packetData{Pts: 0.5, SpliceTime: 2, Preroll: 1.5, BreakEnd: 32}},
+		{"immediate", immediate, 50.0, 0,
+			packetData{Pts: 50, SpliceTime: 50, Immediate: true, BreakEnd: 80}},
+		{"cancel", cancel, 50.0, 0, packetData{Pts: 50}},
+		{"component", component, 50.0, 0, packetData{Pts: 50}},
+		{"time signal", signal(true), 100.0, 0,
+			packetData{Pts: 100, SpliceTime: 104, Preroll: 4, BreakEnd: 164}},
+		{"time signal without a time", signal(false), 100.0, 0,
+			packetData{Pts: 100, SpliceTime: 100, Immediate: true, BreakEnd: 160}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cue := tt.cue
+			cue.PacketData = &packetData{Pts: tt.pts, Pcr: tt.pcr}
+			cue.spliceTiming()
+			if *cue.PacketData != tt.want {
+				t.Fatalf("got %v\nwant %v", mkJson(cue.PacketData), mkJson(&tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package cuei
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Break event types
+const (
+	BreakStarted   = "Break Started"
+	BreakEnded     = "Break Ended"
+	BreakCancelled = "Break Cancelled"
+	BreakOverrun   = "Break Overrun"
+)
+
+// breakStarts are SegmentationTypeIDs that open a break, closed by type + 1.
+var breakStarts = []uint16{0x22, 0x30, 0x32, 0x34, 0x36, 0x38, 0x3A, 0x3C, 0x3E, 0x40, 0x42, 0x44, 0x46}
+
+// Break is an open ad break.
+type Break struct {
+	Program            uint16
+	EventID            uint32
+	SegmentationTypeID uint8 `json:",omitempty"` // zero for Splice Inserts
+	Start              float64
+	Duration           float64 `json:",omitempty"`
+	End                float64 `json:",omitempty"` // expected end, zero when unknown
+	AutoReturn         bool
+	overrun            bool
+}
+
+// BreakEvent is emitted by a Tracker when break state changes.
+type BreakEvent struct {
+	Type  string
+	Pts   float64
+	Early bool `json:",omitempty"` // ended before the expected end
+	Auto  bool `json:",omitempty"` // ended by auto return
+	Break *Break
+	Cue   *Cue `json:"-"`
+}
+
+// Json returns the BreakEvent as JSON
+func (bev *BreakEvent) Json() string {
+	return mkJson(bev)
+}
+
+// Show prints the BreakEvent as JSON
+func (bev *BreakEvent) Show() {
+	fmt.Println(bev.Json())
+}
+
+/*
+Tracker pairs CUE-OUTs and CUE-INs into ad breaks.
+
+Cues are passed to Tracker.Add in the order they arrive.
+Open breaks are kept per program, keyed by splice or segmentation event ID.
+Segmentation breaks of different types, like a Provider Ad Block
+and the Provider Advertisements inside it, are tracked independently.
+Times are compared as Pts, so breaks can span the 33 bit rollover.
+*/
+type Tracker struct {
+	Breaks map[uint16]map[string]*Break // program to open breaks
+}
+
+// NewTracker initializes and returns a *Tracker
+func NewTracker() *Tracker {
+	tr := &Tracker{}
+	tr.Breaks = make(map[uint16]map[string]*Break)
+	return tr
+}
+
+/*
+Add updates break state with cue and returns any BreakEvents.
+
+Add calls Tracker.Tick first when the cue has PacketData,
+so breaks due to end before the cue arrived are closed first.
+*/
+func (tr *Tracker) Add(cue *Cue) []*BreakEvent {
+	if cue.Command == nil {
+		return nil
+	}
+	var bevs []*BreakEvent
+	prgm := uint16(0)
+	if cue.PacketData != nil {
+		prgm = cue.PacketData.Program
+		if cue.PacketData.Pts > 0 {
+			bevs = tr.Tick(prgm, cue.PacketData.Pts)
+		}
+	}
+	pts := cue.splicePts()
+	switch cue.Command.CommandType {
+	case 5:
+		bevs = append(bevs, tr.spliceInsert(cue, prgm, pts)...)
+	case 6:
+		for _, dscptr := range cue.Descriptors {
+			if dscptr.Tag == 2 {
+				bevs = append(bevs, tr.segmentation(cue, dscptr, prgm, pts)...)
+			}
+		}
+	}
+	return bevs
+}
+
+/*
+Tick advances program prgm to pts and returns events for
+breaks that auto return or overrun their expected end,
+in order of expected end, then event ID.
+*/
+func (tr *Tracker) Tick(prgm uint16, pts float64) []*BreakEvent {
+	var keys []string
+	for key, brk := range tr.Breaks[prgm] {
+		if brk.End != 0 && !NewPts(pts).Before(NewPts(brk.End)) {
+			keys = append(keys, key)
+		}
+	}
+	brks := tr.Breaks[prgm]
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := brks[keys[i]], brks[keys[j]]
+		if a.End != b.End {
+			return NewPts(a.End).Before(NewPts(b.End))
+		}
+		if a.EventID != b.EventID {
+			return a.EventID < b.EventID
+		}
+		return keys[i] < keys[j]
+	})
+	var bevs []*BreakEvent
+	for _, key := range keys {
+		brk := brks[key]
+		if brk.AutoReturn {
+			delete(brks, key)
+			bevs = append(bevs, &BreakEvent{Type: BreakEnded, Pts: brk.End, Auto: true, Break: brk})
+			continue
+		}
+		if !brk.overrun {
+			brk.overrun = true
+			bevs = append(bevs, &BreakEvent{Type: BreakOverrun, Pts: pts, Break: brk})
+		}
+	}
+	return bevs
+}
+
+// Open returns the open breaks for program prgm, in order of start, then event ID.
+func (tr *Tracker) Open(prgm uint16) []*Break {
+	var brks []*Break
+	for _, brk := range tr.Breaks[prgm] {
+		brks = append(brks, brk)
+	}
+	sort.Slice(brks, func(i, j int) bool {
+		if brks[i].Start != brks[j].Start {
+			return NewPts(brks[i].Start).Before(NewPts(brks[j].Start))
+		}
+		return brks[i].EventID < brks[j].EventID
+	})
+	return brks
+}
+
+// spliceInsert updates break state for a Splice Insert.
+func (tr *Tracker) spliceInsert(cue *Cue, prgm uint16, pts float64) []*BreakEvent {
+	cmd := cue.Command
+	key := fmt.Sprintf("5:%d", cmd.SpliceEventID)
+	if cmd.SpliceEventCancelIndicator {
+		return tr.cancel(cue, prgm, key, pts)
+	}
+	if cmd.OutOfNetworkIndicator {
+		brk := &Break{Program: prgm, EventID: cmd.SpliceEventID, Start: pts}
+		if cmd.DurationFlag {
+			brk.Duration = cmd.BreakDuration
+			brk.End = NewPts(pts).Add(cmd.BreakDuration).Seconds()
+			brk.AutoReturn = cmd.BreakAutoReturn
+		}
+		return tr.open(cue, key, brk)
+	}
+	return tr.close(cue, prgm, key, pts)
+}
+
+// segmentation updates break state for a Segmentation Descriptor.
+func (tr *Tracker) segmentation(cue *Cue, dscptr Descriptor, prgm uint16, pts float64) []*BreakEvent {
+	eventID := uint32(hex2Int(dscptr.SegmentationEventID))
+	if dscptr.SegmentationEventCancelIndicator {
+		var keys []string
+		suffix := fmt.Sprintf(":%d", eventID)
+		for key := range tr.Breaks[prgm] {
+			if !strings.HasPrefix(key, "5:") && strings.HasSuffix(key, suffix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		var bevs []*BreakEvent
+		for _, key := range keys {
+			bevs = append(bevs, tr.cancel(cue, prgm, key, pts)...)
+		}
+		return bevs
+	}
+	segType := uint16(dscptr.SegmentationTypeID)
+	if IsIn(breakStarts, segType) {
+		key := fmt.Sprintf("%d:%d", segType, eventID)
+		brk := &Break{Program: prgm, EventID: eventID, SegmentationTypeID: dscptr.SegmentationTypeID, Start: pts}
+		if dscptr.SegmentationDurationFlag {
+			brk.Duration = dscptr.SegmentationDuration
+			brk.End = NewPts(pts).Add(dscptr.SegmentationDuration).Seconds()
+		}
+		return tr.open(cue, key, brk)
+	}
+	if IsIn(breakStarts, segType-1) {
+		return tr.close(cue, prgm, fmt.Sprintf("%d:%d", segType-1, eventID), pts)
+	}
+	return nil
+}
+
+// open adds brk unless a break with the same key is already open.
+func (tr *Tracker) open(cue *Cue, key string, brk *Break) []*BreakEvent {
+	brks, ok := tr.Breaks[brk.Program]
+	if !ok {
+		brks = make(map[string]*Break)
+		tr.Breaks[brk.Program] = brks
+	}
+	if _, ok := brks[key]; ok {
+		return nil
+	}
+	brks[key] = brk
+	return []*BreakEvent{{Type: BreakStarted, Pts: brk.Start, Break: brk, Cue: cue}}
+}
+
+// close ends the break for key, the event IDs must match.
+func (tr *Tracker) close(cue *Cue, prgm uint16, key string, pts float64) []*BreakEvent {
+	brks := tr.Breaks[prgm]
+	brk, ok := brks[key]
+	if !ok {
+		return nil
+	}
+	delete(brks, key)
+	early := brk.End > 0 && NewPts(pts).Before(NewPts(brk.End))
+	return []*BreakEvent{{Type: BreakEnded, Pts: pts, Early: early, Break: brk, Cue: cue}}
+}
+
+// cancel removes the break for key.
+func (tr *Tracker) cancel(cue *Cue, prgm uint16, key string, pts float64) []*BreakEvent {
+	brk, ok := tr.Breaks[prgm][key]
+	if !ok {
+		return nil
+	}
+	delete(tr.Breaks[prgm], key)
+	return []*BreakEvent{{Type: BreakCancelled, Pts: pts, Break: brk, Cue: cue}}
+}
+
+/*
+splicePts returns the splice time of the cue in seconds,
+the PTS of the packet carrying the cue for immediate splices.
+*/
+func (cue *Cue) splicePts() float64 {
+	if cue.Command.TimeSpecifiedFlag && !cue.Command.SpliceImmediateFlag {
+		return NewPts(cue.Command.PTS).Add(cue.InfoSection.PtsAdjustment).Seconds()
+	}
+	if cue.PacketData != nil {
+		return cue.PacketData.Pts
+	}
+	return 0
+}
@@ -0,0 +1,287 @@
+package cuei
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// insertCue returns a Splice Insert Cue for Tracker tests.
+func insertCue(id uint32, out bool, pts float64, dur float64) *Cue {
+	cue := &Cue{InfoSection: &InfoSection{}, Command: &Command{}}
+	cmd := cue.Command
+	cmd.CommandType = 5
+	cmd.SpliceEventID = id
+	cmd.OutOfNetworkIndicator = out
+	cmd.ProgramSpliceFlag = true
+	cmd.TimeSpecifiedFlag = true
+	cmd.PTS = pts
+	if dur > 0 {
+		cmd.DurationFlag = true
+		cmd.BreakDuration = dur
+		cmd.BreakAutoReturn = true
+	}
+	return cue
+}
+
+func TestTrackerTickOrder(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		tr := NewTracker()
+		// same end, added out of event ID order
+		tr.Add(insertCue(30, true, 100.0, 30.0))
+		tr.Add(insertCue(10, true, 100.0, 30.0))
+		tr.Add(insertCue(20, true, 100.0, 30.0))
+		tr.Add(insertCue(5, true, 110.0, 30.0))
+		bevs := tr.Tick(0, 200.0)
+		want := []uint32{10, 20, 30, 5}
+		if len(bevs) != len(want) {
+			t.Fatalf("got %d events, want %d", len(bevs), len(want))
+		}
+		for j, bev := range bevs {
+			if bev.Break.EventID != want[j] || bev.Type != BreakEnded {
+				t.Fatalf("event %d is %v %d, want %v %d", j, bev.Type, bev.Break.EventID, BreakEnded, want[j])
+			}
+		}
+	}
+}
+
+func TestTrackerCloseNeedsEventID(t *testing.T) {
+	tr := NewTracker()
+	tr.Add(insertCue(1, true, 100.0, 0))
+	if bevs := tr.Add(insertCue(2, false, 130.0, 0)); len(bevs) != 0 {
+		t.Fatalf("CUE-IN with another event ID closed a break: %v", bevs[0].Json())
+	}
+	if len(tr.Open(0)) != 1 {
+		t.Fatal("break should still be open")
+	}
+	bevs := tr.Add(insertCue(1, false, 130.0, 0))
+	if len(bevs) != 1 || bevs[0].Type != BreakEnded || bevs[0].Break.EventID != 1 {
+		t.Fatal("CUE-IN with the same event ID didn't close the break")
+	}
+}
+
+// cancelCue returns a Splice Insert Cue cancelling event id.
+func cancelCue(id uint32) *Cue {
+	cue := &Cue{InfoSection: &InfoSection{}, Command: &Command{}}
+	cue.Command.CommandType = 5
+	cue.Command.SpliceEventID = id
+	cue.Command.SpliceEventCancelIndicator = true
+	return cue
+}
+
+// segment returns a Segmentation Descriptor for Tracker tests, dur zero for none.
+func segment(id uint32, segType uint8, dur float64) Descriptor {
+	var dscptr Descriptor
+	dscptr.Tag = 2
+	dscptr.SegmentationEventID = fmt.Sprintf("%#x", id)
+	dscptr.SegmentationTypeID = segType
+	if dur > 0 {
+		dscptr.SegmentationDurationFlag = true
+		dscptr.SegmentationDuration = dur
+	}
+	return dscptr
+}
+
+// segmentCancel returns a Segmentation Descriptor cancelling event id.
+func segmentCancel(id uint32) Descriptor {
+	var dscptr Descriptor
+	dscptr.Tag = 2
+	dscptr.SegmentationEventID = fmt.Sprintf("%#x", id)
+	dscptr.SegmentationEventCancelIndicator = true
+	return dscptr
+}
+
+// signalCue returns a Time Signal Cue at pts with dscptrs.
+func signalCue(pts float64, dscptrs ...Descriptor) *Cue {
+	cue := &Cue{InfoSection: &InfoSection{}, Command: &Command{}}
+	cue.Command.CommandType = 6
+	cue.Command.TimeSpecifiedFlag = true
+	cue.Command.PTS = pts
+	cue.Descriptors = dscptrs
+	return cue
+}
+
+// bevString sums up BreakEvents as type, event ID and segmentation type.
+func bevString(bevs []*BreakEvent) string {
+	var parts []string
+	for _, bev := range bevs {
+		part := fmt.Sprintf("%v %d", bev.Type, bev.Break.EventID)
+		if bev.Break.SegmentationTypeID != 0 {
+			part += fmt.Sprintf(" %#x", bev.Break.SegmentationTypeID)
+		}
+		if bev.Early {
+			part += " early"
+		}
+		if bev.Auto {
+			part += " auto"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestTrackerSpliceCancel(t *testing.T) {
+	tr := NewTracker()
+	tr.Add(insertCue(1, true, 100.0, 30.0))
+	tr.Add(insertCue(2, true, 100.0, 0))
+	if bevs := tr.Add(cancelCue(3)); len(bevs) != 0 {
+		t.Fatalf("cancel of an unknown event: %v", bevString(bevs))
+	}
+	bevs := tr.Add(cancelCue(1))
+	if got := bevString(bevs); got != "Break Cancelled 1" {
+		t.Fatalf("got %q", got)
+	}
+	// a cancelled break doesn't auto return
+	if bevs := tr.Tick(0, 200.0); len(bevs) != 0 {
+		t.Fatalf("cancelled break ticked: %v", bevString(bevs))
+	}
+	if open := tr.Open(0); len(open) != 1 || open[0].EventID != 2 {
+		t.Fatalf("open breaks are %v", mkJson(open))
+	}
+}
+
+func TestTrackerSegmentationCancel(t *testing.T) {
+	tr := NewTracker()
+	tr.Add(signalCue(100.0, segment(7, 0x44, 0), segment(7, 0x30, 0), segment(8, 0x30, 0)))
+	// a Splice Insert with the same event ID isn't cancelled
+	tr.Add(insertCue(7, true, 100.0, 0))
+	bevs := tr.Add(signalCue(110.0, segmentCancel(7)))
+	if got := bevString(bevs); got != "Break Cancelled 7 0x30, Break Cancelled 7 0x44" {
+		t.Fatalf("got %q", got)
+	}
+	if got := bevString(tr.Add(cancelCue(7))); got != "Break Cancelled 7" {
+		t.Fatalf("Splice Insert cancel got %q", got)
+	}
+	if open := tr.Open(0); len(open) != 1 || open[0].EventID != 8 {
+		t.Fatalf("open breaks are %v", mkJson(open))
+	}
+}
+
+func TestTrackerOverrun(t *testing.T) {
+	tr := NewTracker()
+	cue := insertCue(1, true, 100.0, 30.0)
+	cue.Command.BreakAutoReturn = false
+	tr.Add(cue)
+	tr.Add(signalCue(100.0, segment(2, 0x34, 30.0)))
+	if bevs := tr.Tick(0, 129.0); len(bevs) != 0 {
+		t.Fatalf("before the end: %v", bevString(bevs))
+	}
+	bevs := tr.Tick(0, 130.0)
+	if got := bevString(bevs); got != "Break Overrun 1, Break Overrun 2 0x34" {
+		t.Fatalf("got %q", got)
+	}
+	if bevs[0].Pts != 130.0 {
+		t.Fatalf("overrun Pts is %v", bevs[0].Pts)
+	}
+	// overrun is reported once, the breaks stay open
+	if bevs := tr.Tick(0, 140.0); len(bevs) != 0 {
+		t.Fatalf("second overrun: %v", bevString(bevs))
+	}
+	if len(tr.Open(0)) != 2 {
+		t.Fatalf("open breaks are %v", mkJson(tr.Open(0)))
+	}
+	bevs = tr.Add(insertCue(1, false, 145.0, 0))
+	bevs = append(bevs, tr.Add(signalCue(145.0, segment(2, 0x35, 0)))...)
+	if got := bevString(bevs); got != "Break Ended 1, Break Ended 2 0x34" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestTrackerEarly(t *testing.T) {
+	tests := []struct {
+		name string
+		in   *Cue
+		want string
+	}{
+		{"early", insertCue(1, false, 120.0, 0), "Break Ended 1 early"},
+		{"on time", insertCue(1, false, 130.0, 0), "Break Ended 1"},
+		{"late", insertCue(1, false, 135.0, 0), "Break Ended 1"},
+	}
+	for _, tt := range tests {
+		tr := NewTracker()
+		cue := insertCue(1, true, 100.0, 30.0)
+		cue.Command.BreakAutoReturn = false
+		tr.Add(cue)
+		if got := bevString(tr.Add(tt.in)); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	// a break without a duration never ends early
+	tr := NewTracker()
+	tr.Add(insertCue(1, true, 100.0, 0))
+	if got := bevString(tr.Add(insertCue(1, false, 101.0, 0))); got != "Break Ended 1" {
+		t.Fatalf("no duration: got %q", got)
+	}
+}
+
+func TestTrackerSegmentationClose(t *testing.T) {
+	tr := NewTracker()
+	bevs := tr.Add(signalCue(100.0, segment(1, 0x34, 0)))
+	if got := bevString(bevs); got != "Break Started 1 0x34" {
+		t.Fatalf("got %q", got)
+	}
+	// the same start again doesn't open another break
+	if bevs := tr.Add(signalCue(101.0, segment(1, 0x34, 0))); len(bevs) != 0 {
+		t.Fatalf("repeated start: %v", bevString(bevs))
+	}
+	// End types for other starts, or other event IDs, don't close it
+	for _, dscptr := range []Descriptor{segment(1, 0x37, 0), segment(1, 0x31, 0), segment(2, 0x35, 0)} {
+		if bevs := tr.Add(signalCue(110.0, dscptr)); len(bevs) != 0 {
+			t.Fatalf("%#x %v closed the break: %v", dscptr.SegmentationTypeID, dscptr.SegmentationEventID, bevString(bevs))
+		}
+	}
+	if got := bevString(tr.Add(signalCue(120.0, segment(1, 0x35, 0)))); got != "Break Ended 1 0x34" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestTrackerNested(t *testing.T) {
+	tr := NewTracker()
+	var bevs []*BreakEvent
+	// an Ad Block holding two Ads, the first Ad has the block's event ID
+	bevs = append(bevs, tr.Add(signalCue(100.0, segment(1, 0x44, 60.0), segment(1, 0x30, 30.0)))...)
+	bevs = append(bevs, tr.Add(signalCue(130.0, segment(1, 0x31, 0)))...)
+	bevs = append(bevs, tr.Add(signalCue(130.0, segment(2, 0x30, 30.0)))...)
+	if open := tr.Open(0); len(open) != 2 || open[0].SegmentationTypeID != 0x44 || open[1].EventID != 2 {
+		t.Fatalf("open breaks are %v", mkJson(open))
+	}
+	bevs = append(bevs, tr.Add(signalCue(160.0, segment(2, 0x31, 0)))...)
+	bevs = append(bevs, tr.Add(signalCue(160.0, segment(1, 0x45, 0)))...)
+	want := "Break Started 1 0x44, Break Started 1 0x30, Break Ended 1 0x30, " +
+		"Break Started 2 0x30, Break Ended 2 0x30, Break Ended 1 0x44"
+	if got := bevString(bevs); got != want {
+		t.Fatalf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestTrackerPtsWrap(t *testing.T) {
+	// 33 bits of 90k ticks wrap at about 95443.717678
+	start := 95430.0
+	end := NewPts(start).Add(30.0).Seconds()
+	if end > 30.0 {
+		t.Fatalf("end %v didn't wrap", end)
+	}
+	tr := NewTracker()
+	tr.Add(insertCue(1, true, start, 30.0))
+	cue := insertCue(2, true, start, 30.0)
+	cue.Command.BreakAutoReturn = false
+	tr.Add(cue)
+	for _, pts := range []float64{95440.0, 95443.7, 0.5, end - 0.1} {
+		if bevs := tr.Tick(0, pts); len(bevs) != 0 {
+			t.Fatalf("Tick at %v: %v", pts, bevString(bevs))
+		}
+	}
+	bevs := tr.Tick(0, end)
+	if got := bevString(bevs); got != "Break Ended 1 auto, Break Overrun 2" {
+		t.Fatalf("got %q", got)
+	}
+	if bevs[0].Pts != end {
+		t.Fatalf("auto return Pts is %v, want %v", bevs[0].Pts, end)
+	}
+	// a CUE-IN after the wrap, before the expected end, is early
+	tr = NewTracker()
+	tr.Add(cue)
+	if got := bevString(tr.Add(insertCue(2, false, 5.0, 0))); got != "Break Ended 2 early" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -1,7 +1,13 @@
 package cuei
 
 import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
 var uriUpids = map[uint8]string{
@@ -10,7 +16,6 @@ var uriUpids = map[uint8]string{
 	0x03: "AdID",
 	0x07: "TID",
 	0x09: "ADI",
-	0x10: "UUID",
 	0x11: "ACR",
 	0x0e: "ADS Info",
 	0x0f: "URI",
@@ -20,11 +25,25 @@ var uriUpids = map[uint8]string{
 Upid is the Struct for Segmentation Upids
 
 Non-standard UPID types are returned as bytes.
+
+Upid Values are in canonical form
+
+	AdID     ABCD1234567H
+	ISAN     0000-0001-8CFA-0000-I, 8 bytes, deprecated
+	V-ISAN   0000-0001-8CFA-0000-I-0000-0000-K
+	AiringID 0x2ca0a18a00000000, TI is the same value in decimal
+	EIDR     10.5240/7791-8534-2C23-9030-8610-5
+	UUID     f81d4fae-7dec-11d0-a765-00a0c91e6bf6
+
+ISANs, AiringIDs and UUIDs with an unexpected length are hex, like 0x00ab12.
+JSON for encoding takes the same forms,
+AiringIDs may also be decimal.
 */
 type Upid struct {
 	Name             string `json:",omitempty"`
 	UpidType         uint8  `json:",omitempty"`
 	Value            string `json:",omitempty"`
+	TI               string `json:",omitempty"`
 	TSID             uint16 `json:",omitempty"`
 	Reserved         uint8  `json:",omitempty"`
 	EndOfDay         uint8  `json:",omitempty"`
@@ -56,6 +75,9 @@ func (upid *Upid) decode(bd *bitDecoder, upidType uint8, upidlen uint8) {
 		case 0x0a:
 			upid.Name = "EIDR"
 			upid.eidr(bd, upidlen)
+		case 0x10:
+			upid.Name = "UUID"
+			upid.uuid(bd, upidlen)
 		case 0x0b:
 			upid.Name = "ATSC"
 			upid.atsc(bd, upidlen)
@@ -74,12 +96,41 @@ func (upid *Upid) decode(bd *bitDecoder, upidType uint8, upidlen uint8) {
 
 // Decode for AirId
 func (upid *Upid) airid(bd *bitDecoder, upidlen uint8) {
-	upid.Value = bd.asHex(uint(upidlen << 3))
+	bites := upidBytes(bd, upidlen)
+	if upidlen != 8 {
+		upid.Value = fmtRawHex(bites)
+		return
+	}
+	airid := binary.BigEndian.Uint64(bites)
+	upid.Value = fmt.Sprintf("0x%016x", airid)
+	upid.TI = strconv.FormatUint(airid, 10)
 }
 
 // Decode for Isan Upid
 func (upid *Upid) isan(bd *bitDecoder, upidlen uint8) {
-	upid.Value = bd.asAscii(uint(upidlen << 3))
+	bites := upidBytes(bd, upidlen)
+	if upidlen != 8 && upidlen != 12 {
+		upid.Value = fmtRawHex(bites)
+		return
+	}
+	upid.Value = fmtIsan(bites)
+}
+
+// Decode for UUID Upid
+func (upid *Upid) uuid(bd *bitDecoder, upidlen uint8) {
+	bites := upidBytes(bd, upidlen)
+	if upidlen != 16 {
+		upid.Value = fmtRawHex(bites)
+		return
+	}
+	upid.Value = fmtUuid(bites)
+}
+
+// upidBytes returns exactly upidlen bytes, asBytes drops leading zeros.
+func upidBytes(bd *bitDecoder, upidlen uint8) []byte {
+	bites := bd.asBytes(uint(upidlen) << 3)
+	out := make([]byte, int(upidlen)-len(bites), upidlen)
+	return append(out, bites...)
 }
 
 // Decode for URI Upid
@@ -103,9 +154,9 @@ func (upid *Upid) eidr(bd *bitDecoder, upidlen uint8) {
 	var astring string
 	nibbles := 20
 	for i := 0; i < nibbles; i++ {
-		astring = fmt.Sprintf("%v%x", astring, bd.uInt8(4))
+		astring = fmt.Sprintf("%v%X", astring, bd.uInt8(4))
 	}
-	upid.Value = fmt.Sprintf("0x%x%v", head, astring)
+	upid.Value = fmtEidr(head, astring)
 }
 
 // Decode for MPU Upid
@@ -131,20 +182,46 @@ func (upid *Upid) mid(bd *bitDecoder, upidlen uint8) {
 	}
 }
 
+// clone returns a copy of the Upid that shares no memory with it, MID Upids included.
+func (upid *Upid) clone() Upid {
+	dupe := *upid
+	dupe.ContentID = append([]byte(nil), upid.ContentID...)
+	dupe.PrivateData = append([]byte(nil), upid.PrivateData...)
+	dupe.Upids = nil
+	for i := range upid.Upids {
+		dupe.Upids = append(dupe.Upids, upid.Upids[i].clone())
+	}
+	return dupe
+}
+
 // Encode Upids
-func (upid *Upid) encode(be *bitEncoder, upidType uint8) {
+func (upid *Upid) encode(be *bitEncoder, upidlen uint8) {
 	switch upid.UpidType {
 	case 0x05, 0x06:
-		upid.encodeIsan(be)
+		upid.encodeIsan(be, upidlen)
 	case 0x08:
-		upid.encodeAirId(be)
+		upid.encodeAirId(be, upidlen)
 	case 0x0a:
 		upid.encodeEidr(be)
+	case 0x10:
+		upid.encodeUuid(be, upidlen)
 	default:
 		upid.encodeUri(be)
 	}
 }
 
+/*
+addUpid adds bites as exactly upidlen bytes, zero padded on the left.
+Extra leading bytes are an error and are dropped.
+*/
+func addUpid(be *bitEncoder, bites []byte, upidlen uint8) {
+	if len(bites) > int(upidlen) {
+		chk(fmt.Errorf("upid is %d bytes, SegmentationUpidLength is %d", len(bites), upidlen))
+		bites = bites[len(bites)-int(upidlen):]
+	}
+	be.AddBytes(bites, uint(upidlen)<<3)
+}
+
 // encode for Uri Upids
 func (upid *Upid) encodeUri(be *bitEncoder) {
 	if len(upid.Value) > 0 {
@@ -153,25 +230,277 @@ func (upid *Upid) encodeUri(be *bitEncoder) {
 }
 
 // encode for AirId
-func (upid *Upid) encodeAirId(be *bitEncoder) {
-	if len(upid.Value) > 0 {
-		be.AddHex64(upid.Value, uint(len(upid.Value)<<3))
+func (upid *Upid) encodeAirId(be *bitEncoder, upidlen uint8) {
+	bites, ok := parseRawHex(upid.Value)
+	if !ok || upidlen == 8 {
+		value := upid.Value
+		if value == "" {
+			value = upid.TI
+		}
+		airid, err := parseAirId(value)
+		chk(err)
+		bites = binary.BigEndian.AppendUint64(nil, airid)
 	}
+	addUpid(be, bites, upidlen)
 }
 
-// encode for Isan Upid
-func (upid *Upid) encodeIsan(be *bitEncoder) {
-	if len(upid.Value) > 0 {
-		be.AddBytes([]byte(upid.Value), uint(len(upid.Value)<<3))
+// encode for Isan Upid, 8 bytes for ISAN and 12 for V-ISAN
+func (upid *Upid) encodeIsan(be *bitEncoder, upidlen uint8) {
+	bites, ok := parseRawHex(upid.Value)
+	if !ok {
+		var err error
+		bites, err = parseIsan(upid.Value)
+		chk(err)
+		if upidlen == 8 && len(bites) == 12 {
+			bites = bites[:8]
+		}
+	}
+	addUpid(be, bites, upidlen)
+}
+
+// encode for UUID Upid
+func (upid *Upid) encodeUuid(be *bitEncoder, upidlen uint8) {
+	bites, ok := parseRawHex(upid.Value)
+	if !ok {
+		var err error
+		bites, err = parseUuid(upid.Value)
+		chk(err)
 	}
+	addUpid(be, bites, upidlen)
 }
 
 // encode for Eidr Upid
 func (upid *Upid) encodeEidr(be *bitEncoder) {
-	be.AddHex64(upid.Value[:6], 16)
-	substring := upid.Value[6:]
-	for _, c := range substring {
+	prefix, nibbles, err := parseEidr(upid.Value)
+	chk(err)
+	be.Add(prefix, 16)
+	for _, c := range nibbles {
 		hexed := fmt.Sprintf("0x%s", string(c))
 		be.AddHex64(hexed, 4)
 	}
 }
+
+/*
+UnmarshalJSON loads a Upid from JSON and
+puts the Value in canonical form.
+Malformed Values return an error.
+*/
+func (upid *Upid) UnmarshalJSON(data []byte) error {
+	type Funk Upid
+	err := json.Unmarshal(data, (*Funk)(upid))
+	if err != nil {
+		return err
+	}
+	if upid.UpidType == 0x08 && upid.Value == "" {
+		upid.Value = upid.TI
+	}
+	value, err := canonicalUpid(upid.UpidType, upid.Value)
+	if err != nil {
+		return err
+	}
+	upid.Value = value
+	return nil
+}
+
+// Validate returns an error if the Upid Value is malformed.
+func (upid *Upid) Validate() error {
+	_, err := canonicalUpid(upid.UpidType, upid.Value)
+	return err
+}
+
+// canonicalUpid checks value and returns it in canonical form for upidType.
+func canonicalUpid(upidType uint8, value string) (string, error) {
+	switch upidType {
+	case 0x03:
+		if !adIdRe.MatchString(value) {
+			return value, fmt.Errorf("malformed AdID %q", value)
+		}
+		return strings.ToUpper(value), nil
+	case 0x05, 0x06:
+		if bites, ok := parseRawHex(value); ok {
+			return fmtRawHex(bites), nil
+		}
+		bites, err := parseIsan(value)
+		if err != nil {
+			return value, err
+		}
+		return fmtIsan(bites), nil
+	case 0x08:
+		airid, err := parseAirId(value)
+		if err != nil {
+			return value, err
+		}
+		return fmt.Sprintf("0x%016x", airid), nil
+	case 0x0a:
+		prefix, nibbles, err := parseEidr(value)
+		return fmtEidr(prefix, nibbles), err
+	case 0x10:
+		if bites, ok := parseRawHex(value); ok {
+			if len(bites) == 16 {
+				return fmtUuid(bites), nil
+			}
+			return fmtRawHex(bites), nil
+		}
+		bites, err := parseUuid(value)
+		if err != nil {
+			return value, err
+		}
+		return fmtUuid(bites), nil
+	}
+	return value, nil
+}
+
+// mod3736 is the ISO 7064 MOD 37,36 alphabet used for check characters.
+const mod3736 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// checkChar returns the ISO 7064 MOD 37,36 check character for chars.
+func checkChar(chars string) byte {
+	m := len(mod3736)
+	p := m
+	for _, c := range strings.ToUpper(chars) {
+		s := (p + strings.IndexRune(mod3736, c)) % m
+		if s == 0 {
+			s = m
+		}
+		p = (s * 2) % (m + 1)
+	}
+	return mod3736[(m+1-p)%m]
+}
+
+var adIdRe = regexp.MustCompile(`^[A-Za-z]{4}[A-Za-z0-9]{7}[HDhd]?$`)
+
+var eidrRe = regexp.MustCompile(`^10\.(\d+)/([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Za-z])$`)
+
+var isanRe = regexp.MustCompile(`^(?:ISAN )?([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Za-z])(?:-([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Za-z]))?$`)
+
+var uuidRe = regexp.MustCompile(`^([0-9A-Fa-f]{8})-([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Fa-f]{4})-([0-9A-Fa-f]{12})$`)
+
+// fmtEidr formats a compact binary EIDR as 10.5240/XXXX-XXXX-XXXX-XXXX-XXXX-C
+func fmtEidr(prefix uint16, nibbles string) string {
+	nibbles = strings.ToUpper(nibbles)
+	var groups []string
+	for i := 0; i+4 <= len(nibbles); i += 4 {
+		groups = append(groups, nibbles[i:i+4])
+	}
+	return fmt.Sprintf("10.%d/%s-%c", prefix, strings.Join(groups, "-"), checkChar(nibbles))
+}
+
+/*
+parseEidr returns the prefix and the 20 hex digits of an EIDR
+as 10.5240/XXXX-XXXX-XXXX-XXXX-XXXX-C or the older 0x1478XXXXXXXXXXXXXXXXXXXX form.
+*/
+func parseEidr(value string) (uint16, string, error) {
+	if strings.HasPrefix(value, "0x") && len(value) == 26 {
+		prefix, err := strconv.ParseUint(value[2:6], 16, 16)
+		if err == nil && isHex(value[6:]) {
+			return uint16(prefix), strings.ToUpper(value[6:]), nil
+		}
+	}
+	m := eidrRe.FindStringSubmatch(value)
+	if m == nil {
+		return 0, "", fmt.Errorf("malformed EIDR %q", value)
+	}
+	prefix, err := strconv.ParseUint(m[1], 10, 16)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed EIDR prefix %q", value)
+	}
+	nibbles := strings.ToUpper(strings.Join(m[2:7], ""))
+	if checkChar(nibbles) != strings.ToUpper(m[7])[0] {
+		return 0, "", fmt.Errorf("bad EIDR check character %q", value)
+	}
+	return uint16(prefix), nibbles, nil
+}
+
+/*
+fmtIsan formats 12 bytes as a V-ISAN, XXXX-XXXX-XXXX-XXXX-C-XXXX-XXXX-C,
+or 8 bytes as an ISAN without a version, XXXX-XXXX-XXXX-XXXX-C.
+*/
+func fmtIsan(bites []byte) string {
+	work := fmt.Sprintf("%X", bites[:8])
+	isan := fmt.Sprintf("%s-%s-%s-%s-%c", work[0:4], work[4:8], work[8:12], work[12:16], checkChar(work))
+	if len(bites) < 12 {
+		return isan
+	}
+	ver := fmt.Sprintf("%X", bites[8:12])
+	return fmt.Sprintf("%s-%s-%s-%c", isan, ver[0:4], ver[4:8], checkChar(work+ver))
+}
+
+// parseIsan returns an ISAN as 8 bytes, or a V-ISAN as 12, checking the check characters.
+func parseIsan(value string) ([]byte, error) {
+	m := isanRe.FindStringSubmatch(value)
+	if m == nil {
+		return nil, fmt.Errorf("malformed ISAN %q", value)
+	}
+	work := strings.ToUpper(strings.Join(m[1:5], ""))
+	if checkChar(work) != strings.ToUpper(m[5])[0] {
+		return nil, fmt.Errorf("bad ISAN check character %q", value)
+	}
+	if m[6] == "" {
+		bites, _ := hex.DecodeString(work)
+		return bites, nil
+	}
+	ver := strings.ToUpper(m[6] + m[7])
+	if checkChar(work+ver) != strings.ToUpper(m[8])[0] {
+		return nil, fmt.Errorf("bad ISAN check character %q", value)
+	}
+	bites, _ := hex.DecodeString(work + ver)
+	return bites, nil
+}
+
+// fmtUuid formats 16 bytes as a UUID, xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx
+func fmtUuid(bites []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", bites[0:4], bites[4:6], bites[6:8], bites[8:10], bites[10:16])
+}
+
+// parseUuid returns a UUID as 16 bytes.
+func parseUuid(value string) ([]byte, error) {
+	m := uuidRe.FindStringSubmatch(value)
+	if m == nil {
+		return nil, fmt.Errorf("malformed UUID %q", value)
+	}
+	bites, _ := hex.DecodeString(strings.Join(m[1:6], ""))
+	return bites, nil
+}
+
+// fmtRawHex formats bites as 0x and two hex digits a byte, keeping leading zeros.
+func fmtRawHex(bites []byte) string {
+	return "0x" + hex.EncodeToString(bites)
+}
+
+// parseRawHex returns the bytes of a 0x prefixed hex value, an odd digit count is zero padded.
+func parseRawHex(value string) ([]byte, bool) {
+	if !strings.HasPrefix(value, "0x") && !strings.HasPrefix(value, "0X") {
+		return nil, false
+	}
+	digits := value[2:]
+	if len(digits)%2 == 1 {
+		digits = "0" + digits
+	}
+	bites, err := hex.DecodeString(digits)
+	return bites, err == nil && len(bites) > 0
+}
+
+// parseAirId returns an AiringID given as hex with a 0x prefix or as decimal.
+func parseAirId(value string) (uint64, error) {
+	var airid uint64
+	var err error
+	if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+		airid, err = strconv.ParseUint(value[2:], 16, 64)
+	} else {
+		airid, err = strconv.ParseUint(value, 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("malformed AiringID %q", value)
+	}
+	return airid, nil
+}
+
+// isHex is true when str is all hex digits.
+func isHex(str string) bool {
+	for _, c := range str {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return len(str) > 0
+}
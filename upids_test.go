@@ -0,0 +1,108 @@
+package cuei
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// segCue returns a Time Signal section with one Segmentation Descriptor, type 0x34, carrying upid.
+func segCue(upidType uint8, upid []byte) []byte {
+	dscptr := []byte{0x02, 0, 'C', 'U', 'E', 'I', 0, 0, 0, 1, 0x7f, 0xbf, upidType, byte(len(upid))}
+	dscptr = append(dscptr, upid...)
+	dscptr = append(dscptr, 0x34, 1, 1, 0, 0)
+	dscptr[1] = byte(len(dscptr) - 2)
+	sec := []byte{0xfc, 0x30, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xf0, 5, 6, 0xfe, 0, 0x0a, 0xbc, 0xde}
+	sec = append(sec, 0, byte(len(dscptr)))
+	return finishSection(append(sec, dscptr...))
+}
+
+func TestUpidRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		upidType uint8
+		upid     []byte
+		value    string
+	}{
+		{"ISAN", 0x05, []byte{0, 0, 0, 1, 0x8c, 0xfa, 0, 0}, "0000-0001-8CFA-0000-I"},
+		{"V-ISAN", 0x06, []byte{0, 0, 0, 1, 0x8c, 0xfa, 0, 0, 0, 0, 0, 0}, "0000-0001-8CFA-0000-I-0000-0000-K"},
+		{"ISAN odd length", 0x06, []byte{0, 0, 0x12, 0x34}, "0x00001234"},
+		{"AiringID", 0x08, []byte{0x2c, 0xa0, 0xa1, 0x8a, 0, 0, 0, 0}, "0x2ca0a18a00000000"},
+		{"AiringID leading zeros", 0x08, []byte{0, 0, 0, 0, 0, 0, 0x30, 0x39}, "0x0000000000003039"},
+		{"AiringID 4 bytes", 0x08, []byte{0, 0, 0x30, 0x39}, "0x00003039"},
+		{"UUID", 0x10, []byte{0xf8, 0x1d, 0x4f, 0xae, 0x7d, 0xec, 0x11, 0xd0, 0xa7, 0x65, 0, 0xa0, 0xc9, 0x1e, 0x6b, 0xf6}, "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"},
+		{"UUID 8 bytes", 0x10, []byte{0, 0x1d, 0x4f, 0xae, 0x7d, 0xec, 0x11, 0xd0}, "0x001d4fae7dec11d0"},
+		{"EIDR", 0x0a, []byte{0x14, 0x78, 0x77, 0x91, 0x85, 0x34, 0x2c, 0x23, 0x90, 0x30, 0x86, 0x10}, "10.5240/7791-8534-2C23-9030-8610-5"},
+		{"AdID", 0x03, []byte("ABCD0001000H"), "ABCD0001000H"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sec := segCue(tt.upidType, tt.upid)
+			cue := NewCue()
+			if !cue.Decode(sec) {
+				t.Fatal("decode failed")
+			}
+			dscptr := cue.Descriptors[0]
+			if got := dscptr.SegmentationUpid.Value; got != tt.value {
+				t.Fatalf("Value is %q, want %q", got, tt.value)
+			}
+			if dscptr.SegmentationTypeID != 0x34 {
+				t.Fatalf("SegmentationTypeID is %d, want 52", dscptr.SegmentationTypeID)
+			}
+			if enc := cue.Encode(); !bytes.Equal(enc, sec) {
+				t.Fatalf("Encode\n got %x\nwant %x", enc, sec)
+			}
+			js, err := json.Marshal(cue)
+			if err != nil {
+				t.Fatal(err)
+			}
+			again := NewCue()
+			if err := json.Unmarshal(js, again); err != nil {
+				t.Fatal(err)
+			}
+			if enc := again.Encode(); !bytes.Equal(enc, sec) {
+				t.Fatalf("JSON round trip\n got %x\nwant %x", enc, sec)
+			}
+		})
+	}
+}
+
+func TestUpidTI(t *testing.T) {
+	cue := NewCue()
+	cue.Decode(segCue(0x08, []byte{0, 0, 0, 0, 0, 0, 0x30, 0x39}))
+	if ti := cue.Descriptors[0].SegmentationUpid.TI; ti != "12345" {
+		t.Fatalf("TI is %q, want 12345", ti)
+	}
+	var upid Upid
+	if err := json.Unmarshal([]byte(`{"UpidType": 8, "TI": "12345"}`), &upid); err != nil {
+		t.Fatal(err)
+	}
+	if upid.Value != "0x0000000000003039" {
+		t.Fatalf("Value from TI is %q", upid.Value)
+	}
+}
+
+func TestUpidValidate(t *testing.T) {
+	tests := []struct {
+		upidType uint8
+		value    string
+		ok       bool
+	}{
+		{0x05, "0000-0001-8CFA-0000-I", true},
+		{0x05, "0000-0001-8CFA-0000-J", false},
+		{0x06, "0000-0001-8CFA-0000-I-0000-0000-K", true},
+		{0x06, "0x00001234", true},
+		{0x06, "ISAN 1234", false},
+		{0x10, "f81d4fae-7dec-11d0-a765-00a0c91e6bf6", true},
+		{0x10, "0x001d4fae7dec11d0", true},
+		{0x10, "f81d4fae-7dec-11d0-a765", false},
+		{0x08, "12345", true},
+		{0x08, "12x45", false},
+	}
+	for _, tt := range tests {
+		upid := &Upid{UpidType: tt.upidType, Value: tt.value}
+		if err := upid.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%#x, %q) = %v", tt.upidType, tt.value, err)
+		}
+	}
+}